@@ -0,0 +1,87 @@
+// s3-standin is a local s3 compatible store for trying the s3 blob store out:
+// it checks signatures and keeps objects put with path style requests in memory
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"reddit_clone/internals/sigv4"
+)
+
+type object struct {
+	contentType string
+	data        []byte
+}
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	accessKey := flag.String("access-key", "", "expected access key, signatures are not checked if empty")
+	secretKey := flag.String("secret-key", "", "secret key signatures are checked with")
+	region := flag.String("region", "us-east-1", "region signatures are checked with")
+	flag.Parse()
+
+	var mu sync.Mutex
+	objects := map[string]object{}
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		if *accessKey != "" {
+			creds := sigv4.Credentials{AccessKey: *accessKey, SecretKey: *secretKey, Region: *region, Service: "s3"}
+			if r.Header.Get(sigv4.PayloadHeader) != sigv4.PayloadHash(body) {
+				log.Printf("%s %s: payload hash mismatch\n", r.Method, r.URL.Path)
+				s3Error(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+				return
+			} else if r.Header.Get("Authorization") != sigv4.Authorization(r, creds) {
+				log.Printf("%s %s: bad signature\n", r.Method, r.URL.Path)
+				s3Error(w, http.StatusForbidden, "SignatureDoesNotMatch")
+				return
+			}
+		}
+		// path is /bucket/key
+		if strings.Count(strings.Trim(r.URL.Path, "/"), "/") < 1 {
+			s3Error(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "PUT":
+			objects[r.URL.Path] = object{contentType: r.Header.Get("Content-Type"), data: body}
+			log.Printf("PUT %s: %d bytes\n", r.URL.Path, len(body))
+		case "GET":
+			obj, ok := objects[r.URL.Path]
+			if !ok {
+				log.Printf("GET %s: no such key\n", r.URL.Path)
+				s3Error(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			log.Printf("GET %s: %d bytes\n", r.URL.Path, len(obj.data))
+			w.Header().Set("Content-Type", obj.contentType)
+			w.Write(obj.data)
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			log.Printf("DELETE %s\n", r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+		}
+	})
+	fmt.Printf("Listen for s3 requests on %v\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>%s</Code></Error>\n", code)
+}
@@ -0,0 +1,51 @@
+// webhook-receiver is a local endpoint for trying webhooks out:
+// it checks signatures, prints payloads and can fail on purpose to show retries
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"reddit_clone/internals/webhooks"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "address to listen on")
+	secret := flag.String("secret", "", "webhook secret, signatures are not checked if empty")
+	fail := flag.Int64("fail", 0, "how many first requests get 500 response")
+	tolerance := flag.Duration("tolerance", webhooks.DefaultTolerance, "how old or how far in the future signed timestamp can be")
+	flag.Parse()
+
+	var received int64
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "cannot read body", http.StatusBadRequest)
+			return
+		}
+		n := atomic.AddInt64(&received, 1)
+		event := r.Header.Get(webhooks.EventHeader)
+		delivery := r.Header.Get(webhooks.DeliveryHeader)
+		if *secret != "" {
+			timestamp := r.Header.Get(webhooks.TimestampHeader)
+			if !webhooks.Verify(*secret, timestamp, body, r.Header.Get(webhooks.SignatureHeader), *tolerance) {
+				log.Printf("#%d %s %s: bad signature or stale timestamp\n", n, event, delivery)
+				http.Error(w, "bad signature or stale timestamp", http.StatusUnauthorized)
+				return
+			}
+		}
+		if n <= *fail {
+			log.Printf("#%d %s %s: failing on purpose\n", n, event, delivery)
+			http.Error(w, "failing on purpose", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("#%d %s %s: %s\n", n, event, delivery, body)
+		fmt.Fprintln(w, "ok")
+	})
+	fmt.Printf("Listen for webhooks on %v\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
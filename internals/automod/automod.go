@@ -0,0 +1,196 @@
+package automod
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"reddit_clone/internals/misc"
+)
+
+const (
+	TargetAny     = ""
+	TargetPost    = "post"
+	TargetComment = "comment"
+)
+
+const (
+	ActionRemove   = "remove"
+	ActionFlag     = "flag"
+	ActionSetFlair = "set_flair"
+	ActionComment  = "comment"
+)
+
+type Action struct {
+	Type    string `json:"type"              bson:"type"`
+	Reason  string `json:"reason,omitempty"  bson:"reason,omitempty"`
+	Flair   string `json:"flair,omitempty"   bson:"flair,omitempty"`
+	Comment string `json:"comment,omitempty" bson:"comment,omitempty"`
+}
+
+// Rule matches when all of its non-empty conditions match, then its Action is triggered
+// Rules with ReportCount are checked only when content is reported and fire once,
+// when the number of reports reaches ReportCount; other rules are checked when content is made
+type Rule struct {
+	Name         string   `json:"name"                     bson:"name"`
+	Target       string   `json:"target,omitempty"         bson:"target,omitempty"`
+	TitleRegex   string   `json:"title_regex,omitempty"    bson:"title_regex,omitempty"`
+	BodyRegex    string   `json:"body_regex,omitempty"     bson:"body_regex,omitempty"`
+	Domains      []string `json:"domains,omitempty"        bson:"domains,omitempty"`
+	AccountAgeLt string   `json:"account_age_lt,omitempty" bson:"account_age_lt,omitempty"` // like "72h"
+	KarmaLt      *int     `json:"karma_lt,omitempty"       bson:"karma_lt,omitempty"`
+	ReportCount  int      `json:"report_count,omitempty"   bson:"report_count,omitempty"`
+	Action       Action   `json:"action"                   bson:"action"`
+}
+
+// Subject is a post or a comment checked by rules; comments have no Title and URL
+type Subject struct {
+	Target     string
+	Title      string
+	Body       string
+	URL        string
+	AccountAge time.Duration
+	Karma      int
+	Reports    int
+	OnReport   bool
+}
+
+// Validate checks rules before they are stored, so Evaluate can ignore bad rules silently
+func Validate(rules []Rule) error {
+	for i, rule := range rules {
+		if err := validateRule(rule); err != nil {
+			return fmt.Errorf("rule %d (%s): %w", i, rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateRule(rule Rule) error {
+	if rule.Name == "" {
+		return errors.New("name cannot be blank")
+	}
+	switch rule.Target {
+	case TargetAny, TargetPost, TargetComment:
+	default:
+		return errors.New("target must be post, comment or empty")
+	}
+	if _, err := regexp.Compile(rule.TitleRegex); err != nil {
+		return fmt.Errorf("bad title_regex: %w", err)
+	}
+	if _, err := regexp.Compile(rule.BodyRegex); err != nil {
+		return fmt.Errorf("bad body_regex: %w", err)
+	}
+	if rule.AccountAgeLt != "" {
+		if age, err := time.ParseDuration(rule.AccountAgeLt); err != nil || age <= 0 {
+			return errors.New("account_age_lt must be a positive duration like 72h")
+		}
+	}
+	if rule.ReportCount < 0 {
+		return errors.New("report_count cannot be negative")
+	}
+	if rule.TitleRegex == "" && rule.BodyRegex == "" && len(rule.Domains) == 0 &&
+		rule.AccountAgeLt == "" && rule.KarmaLt == nil && rule.ReportCount == 0 {
+		return errors.New("rule has no conditions")
+	}
+
+	switch rule.Action.Type {
+	case ActionRemove, ActionFlag:
+	case ActionSetFlair:
+		if rule.Action.Flair == "" {
+			return errors.New("flair cannot be blank")
+		} else if rule.Target != TargetPost {
+			return errors.New("only posts have flair, target must be post")
+		}
+	case ActionComment:
+		if rule.Action.Comment == "" {
+			return errors.New("comment cannot be blank")
+		}
+	default:
+		return errors.New("action type must be remove, flag, set_flair or comment")
+	}
+	return nil
+}
+
+// Evaluate returns actions of all rules matching subject, in order of rules
+func Evaluate(rules []Rule, subject Subject) []Action {
+	actions := make([]Action, 0)
+	for _, rule := range rules {
+		if compile(rule).match(subject) {
+			actions = append(actions, rule.Action)
+		}
+	}
+	return actions
+}
+
+func Match(rule Rule, subject Subject) bool {
+	return compile(rule).match(subject)
+}
+
+// compiledRule keeps parsed conditions of a rule; rules are loaded with their category
+// for every check, so they are compiled for it and dropped with it
+type compiledRule struct {
+	Rule
+	title *regexp.Regexp // nil when condition is empty
+	body  *regexp.Regexp
+	age   time.Duration
+	bad   bool // rule which does not compile never matches
+}
+
+func compile(rule Rule) compiledRule {
+	cr := compiledRule{Rule: rule}
+	var err error
+	if rule.TitleRegex != "" {
+		if cr.title, err = regexp.Compile(rule.TitleRegex); err != nil {
+			cr.bad = true
+		}
+	}
+	if rule.BodyRegex != "" {
+		if cr.body, err = regexp.Compile(rule.BodyRegex); err != nil {
+			cr.bad = true
+		}
+	}
+	if rule.AccountAgeLt != "" {
+		if cr.age, err = time.ParseDuration(rule.AccountAgeLt); err != nil {
+			cr.bad = true
+		}
+	}
+	return cr
+}
+
+func (cr compiledRule) match(subject Subject) bool {
+	if cr.bad {
+		return false
+	}
+	if cr.Target != TargetAny && cr.Target != subject.Target {
+		return false
+	}
+	if subject.OnReport != (cr.ReportCount > 0) {
+		return false
+	} else if subject.OnReport && subject.Reports != cr.ReportCount {
+		return false
+	}
+	if (cr.title != nil && !cr.title.MatchString(subject.Title)) || (cr.body != nil && !cr.body.MatchString(subject.Body)) {
+		return false
+	}
+	if len(cr.Domains) > 0 && !MatchDomain(cr.Domains, subject.URL) {
+		return false
+	}
+	if cr.AccountAgeLt != "" && subject.AccountAge >= cr.age {
+		return false
+	}
+	if cr.KarmaLt != nil && subject.Karma >= *cr.KarmaLt {
+		return false
+	}
+	return true
+}
+
+// MatchDomain checks if host of rawURL is one of domains or their subdomain
+func MatchDomain(domains []string, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	return misc.HostInDomains(u.Hostname(), domains)
+}
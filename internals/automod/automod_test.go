@@ -0,0 +1,153 @@
+package automod
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestValidate(t *testing.T) {
+	remove := Action{Type: ActionRemove}
+	tests := []struct {
+		name  string
+		rule  Rule
+		valid bool
+	}{
+		{"title regex", Rule{Name: "spam", TitleRegex: "(?i)buy now", Action: remove}, true},
+		{"body regex", Rule{Name: "spam", BodyRegex: "casino", Action: Action{Type: ActionFlag}}, true},
+		{"domains", Rule{Name: "shorteners", Domains: []string{"bit.ly"}, Action: remove}, true},
+		{"account age", Rule{Name: "new", AccountAgeLt: "72h", Action: remove}, true},
+		{"karma", Rule{Name: "low karma", KarmaLt: intPtr(-5), Action: remove}, true},
+		{"reports", Rule{Name: "reported", ReportCount: 3, Action: remove}, true},
+		{"flair", Rule{Name: "q", Target: TargetPost, TitleRegex: `\?$`, Action: Action{Type: ActionSetFlair, Flair: "question"}}, true},
+		{"comment", Rule{Name: "welcome", AccountAgeLt: "24h", Action: Action{Type: ActionComment, Comment: "hi"}}, true},
+
+		{"no name", Rule{TitleRegex: "x", Action: remove}, false},
+		{"bad target", Rule{Name: "r", Target: "user", TitleRegex: "x", Action: remove}, false},
+		{"bad title regex", Rule{Name: "r", TitleRegex: "(", Action: remove}, false},
+		{"bad body regex", Rule{Name: "r", BodyRegex: "[a-", Action: remove}, false},
+		{"bad account age", Rule{Name: "r", AccountAgeLt: "3 days", Action: remove}, false},
+		{"negative account age", Rule{Name: "r", AccountAgeLt: "-1h", Action: remove}, false},
+		{"negative reports", Rule{Name: "r", ReportCount: -1, TitleRegex: "x", Action: remove}, false},
+		{"no conditions", Rule{Name: "r", Action: remove}, false},
+		{"blank flair", Rule{Name: "r", Target: TargetPost, TitleRegex: "x", Action: Action{Type: ActionSetFlair}}, false},
+		{"flair on comments", Rule{Name: "r", TitleRegex: "x", Action: Action{Type: ActionSetFlair, Flair: "f"}}, false},
+		{"blank comment", Rule{Name: "r", TitleRegex: "x", Action: Action{Type: ActionComment}}, false},
+		{"bad action", Rule{Name: "r", TitleRegex: "x", Action: Action{Type: "ban"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate([]Rule{tt.rule})
+			if tt.valid && err != nil {
+				t.Errorf("Validate() = %v, want no error", err)
+			} else if !tt.valid && err == nil {
+				t.Errorf("Validate() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	post := Subject{
+		Target:     TargetPost,
+		Title:      "Buy now, cheap watches",
+		Body:       "visit my shop",
+		URL:        "https://shop.bit.ly/watches",
+		AccountAge: time.Hour,
+		Karma:      1,
+	}
+	comment := Subject{Target: TargetComment, Body: "great post", AccountAge: 30 * 24 * time.Hour, Karma: 100}
+	reported := Subject{Target: TargetComment, Body: "meh", Reports: 3, OnReport: true}
+
+	tests := []struct {
+		name    string
+		rule    Rule
+		subject Subject
+		want    bool
+	}{
+		{"title regex", Rule{TitleRegex: "(?i)^buy now"}, post, true},
+		{"title regex miss", Rule{TitleRegex: "^buy now"}, post, false},
+		{"body regex", Rule{BodyRegex: "shop"}, post, true},
+		{"all conditions", Rule{TitleRegex: "(?i)watches", BodyRegex: "shop", Domains: []string{"bit.ly"}}, post, true},
+		{"one condition misses", Rule{TitleRegex: "(?i)watches", BodyRegex: "casino"}, post, false},
+		{"bad regex never matches", Rule{TitleRegex: "("}, post, false},
+		{"domain", Rule{Domains: []string{"bit.ly"}}, post, true},
+		{"domain miss", Rule{Domains: []string{"example.com"}}, post, false},
+		{"domain without url", Rule{Domains: []string{"bit.ly"}}, comment, false},
+		{"young account", Rule{AccountAgeLt: "72h"}, post, true},
+		{"old account", Rule{AccountAgeLt: "72h"}, comment, false},
+		{"low karma", Rule{KarmaLt: intPtr(10)}, post, true},
+		{"enough karma", Rule{KarmaLt: intPtr(10)}, comment, false},
+		{"post target", Rule{Target: TargetPost, BodyRegex: "."}, comment, false},
+		{"comment target", Rule{Target: TargetComment, BodyRegex: "great"}, comment, true},
+		{"report count reached", Rule{ReportCount: 3}, reported, true},
+		{"report count not reached", Rule{ReportCount: 5}, reported, false},
+		{"report rule on creation", Rule{ReportCount: 3}, comment, false},
+		{"creation rule on report", Rule{BodyRegex: "meh"}, reported, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Match(tt.rule, tt.subject); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rules := []Rule{
+		{Name: "spam", TitleRegex: "(?i)buy", Action: Action{Type: ActionRemove, Reason: "spam"}},
+		{Name: "flair", Target: TargetPost, TitleRegex: `\?$`, Action: Action{Type: ActionSetFlair, Flair: "question"}},
+		{Name: "new", AccountAgeLt: "24h", Action: Action{Type: ActionFlag}},
+	}
+	tests := []struct {
+		name    string
+		subject Subject
+		want    []Action
+	}{
+		{"nothing", Subject{Target: TargetPost, Title: "hello", AccountAge: 48 * time.Hour}, []Action{}},
+		{"in order of rules", Subject{Target: TargetPost, Title: "buy?", AccountAge: time.Hour}, []Action{
+			{Type: ActionRemove, Reason: "spam"},
+			{Type: ActionSetFlair, Flair: "question"},
+			{Type: ActionFlag},
+		}},
+		{"target filters", Subject{Target: TargetComment, Title: "why?", AccountAge: time.Hour}, []Action{
+			{Type: ActionFlag},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Evaluate(rules, tt.subject); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchDomain(t *testing.T) {
+	domains := []string{"example.com", "Bit.ly"}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/a", true},
+		{"https://EXAMPLE.com./a", true},
+		{"https://www.example.com", true},
+		{"http://bit.ly/x", true},
+		{"https://notexample.com", false},
+		{"https://example.com.evil.org", false},
+		{"not a url", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := MatchDomain(domains, tt.url); got != tt.want {
+				t.Errorf("MatchDomain(%q) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Package events fans out change notifications to subscribers of posts, categories and inboxes.
+// Events only tell what changed, clients fetch the data itself through the api,
+// so everything they get is filtered for them as usual.
+package events
+
+import (
+	"sync"
+)
+
+const (
+	PostCreated     = "post_created"
+	PostDeleted     = "post_deleted"
+	PostRestored    = "post_restored"
+	PostUpdated     = "post_updated"
+	PostVoted       = "post_voted"
+	CommentCreated  = "comment_created"
+	CommentDeleted  = "comment_deleted"
+	CommentRestored = "comment_restored"
+	CommentVoted    = "comment_voted"
+	Notification    = "notification"
+	Message         = "message"
+)
+
+// subscribers lagging behind by more events lose new ones
+const subscriberBuffer = 64
+
+type Event struct {
+	Type      string `json:"type"`
+	Topic     string `json:"topic"`
+	PostID    string `json:"post_id,omitempty"`
+	CommentID string `json:"comment_id,omitempty"`
+	Category  string `json:"category,omitempty"`
+}
+
+func PostTopic(postID string) string {
+	return "post:" + postID
+}
+
+func CategoryTopic(category string) string {
+	return "category:" + category
+}
+
+func InboxTopic(userID string) string {
+	return "inbox:" + userID
+}
+
+type Broker interface {
+	Publish(event Event) error
+	// returned channel is closed after cancel is called
+	Subscribe(topics []string) (events <-chan Event, cancel func())
+}
+
+//---------------------------------------Local broker----------------------------------//
+
+// LocalBroker delivers events to subscribers of this process only
+type LocalBroker struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{
+		subscribers: make(map[string]map[chan Event]struct{}),
+	}
+}
+
+// never blocks: slow subscribers miss events instead of holding up everyone
+func (lb *LocalBroker) Publish(event Event) error {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	for ch := range lb.subscribers[event.Topic] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+func (lb *LocalBroker) Subscribe(topics []string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	lb.mu.Lock()
+	for _, topic := range topics {
+		if lb.subscribers[topic] == nil {
+			lb.subscribers[topic] = make(map[chan Event]struct{})
+		}
+		lb.subscribers[topic][ch] = struct{}{}
+	}
+	lb.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			lb.mu.Lock()
+			for _, topic := range topics {
+				delete(lb.subscribers[topic], ch)
+				if len(lb.subscribers[topic]) == 0 {
+					delete(lb.subscribers, topic)
+				}
+			}
+			lb.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
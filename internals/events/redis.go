@@ -0,0 +1,93 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const redisPrefix = "events:"
+
+// RedisBroker publishes events through redis pub/sub, so subscribers of every instance get them;
+// each instance receives all events once and fans them out with a local broker
+type RedisBroker struct {
+	url   string
+	local *LocalBroker
+
+	mu  sync.Mutex
+	pub redis.Conn
+}
+
+func NewRedisBroker(url string) (*RedisBroker, error) {
+	pub, err := redis.DialURL(url)
+	if err != nil {
+		return nil, err
+	}
+	rb := &RedisBroker{
+		url:   url,
+		local: NewLocalBroker(),
+		pub:   pub,
+	}
+	go rb.receive()
+	return rb, nil
+}
+
+func (rb *RedisBroker) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.pub.Err() != nil {
+		if rb.pub, err = redis.DialURL(rb.url); err != nil {
+			return err
+		}
+	}
+	_, err = rb.pub.Do("PUBLISH", redisPrefix+event.Topic, data)
+	return err
+}
+
+func (rb *RedisBroker) Subscribe(topics []string) (<-chan Event, func()) {
+	return rb.local.Subscribe(topics)
+}
+
+// forwards events from redis to local subscribers, reconnects until the process ends
+func (rb *RedisBroker) receive() {
+	for {
+		if err := rb.receiveOnce(); err != nil {
+			log.Printf("events/redis.go: receive: %s\n", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (rb *RedisBroker) receiveOnce() error {
+	conn, err := redis.DialURL(rb.url)
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.PSubscribe(redisPrefix + "*"); err != nil {
+		return err
+	}
+	for {
+		switch msg := psc.Receive().(type) {
+		case redis.Message:
+			event := Event{}
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				log.Printf("events/redis.go: receive: bad event on %s: %s\n", msg.Channel, err)
+				continue
+			}
+			event.Topic = strings.TrimPrefix(msg.Channel, redisPrefix)
+			rb.local.Publish(event)
+		case error:
+			return msg
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package feeds renders lists of posts as RSS 2.0 and Atom documents.
+package feeds
+
+import (
+	"encoding/xml"
+	"html"
+	"time"
+)
+
+// Entry is one post; Link leads to the linked page for link posts and equals Permalink for text posts
+type Entry struct {
+	ID        string
+	Title     string
+	Link      string
+	Permalink string
+	Author    string
+	Category  string
+	Text      string
+	HTML      string // text rendered from markdown, used instead of text if set
+	Published time.Time
+}
+
+type Feed struct {
+	Title       string
+	Description string
+	Link        string // page the feed is made of
+	Self        string // the feed itself
+	Updated     time.Time
+	Entries     []Entry
+}
+
+// guid does not depend on host, so it stays the same wherever the feed is served from
+func guid(postID string) string {
+	return "urn:reddit-clone:post:" + postID
+}
+
+//-----------------------------------------RSS-----------------------------------------//
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Comments    string  `xml:"comments"`
+	Description string  `xml:"description,omitempty"`
+	Category    string  `xml:"category"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+func RSS(feed Feed) ([]byte, error) {
+	doc := rss{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         feed.Title,
+			Link:          feed.Link,
+			Description:   feed.Description,
+			LastBuildDate: feed.Updated.Format(time.RFC1123Z),
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: feed.Self},
+			Items:         make([]rssItem, 0, len(feed.Entries)),
+		},
+	}
+	for _, entry := range feed.Entries {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			Comments:    entry.Permalink,
+			Description: entry.content(),
+			Category:    entry.Category,
+			GUID:        rssGUID{Value: guid(entry.ID)},
+			PubDate:     entry.Published.Format(time.RFC1123Z),
+		})
+	}
+	return marshal(doc)
+}
+
+//-----------------------------------------Atom----------------------------------------//
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string       `xml:"title"`
+	ID        string       `xml:"id"`
+	Links     []atomLink   `xml:"link"`
+	Updated   string       `xml:"updated"`
+	Published string       `xml:"published"`
+	Author    atomAuthor   `xml:"author"`
+	Category  atomCategory `xml:"category"`
+	Content   *atomContent `xml:"content,omitempty"`
+}
+
+func Atom(feed Feed) ([]byte, error) {
+	doc := atomFeed{
+		Title: feed.Title,
+		ID:    feed.Self,
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: feed.Self},
+			{Rel: "alternate", Type: "text/html", Href: feed.Link},
+		},
+		Updated: feed.Updated.Format(time.RFC3339),
+		Entries: make([]atomEntry, 0, len(feed.Entries)),
+	}
+	for _, entry := range feed.Entries {
+		atom := atomEntry{
+			Title:     entry.Title,
+			ID:        guid(entry.ID),
+			Links:     []atomLink{{Rel: "alternate", Href: entry.Link}},
+			Updated:   entry.Published.Format(time.RFC3339),
+			Published: entry.Published.Format(time.RFC3339),
+			Author:    atomAuthor{Name: entry.Author},
+			Category:  atomCategory{Term: entry.Category},
+		}
+		if entry.Link != entry.Permalink {
+			atom.Links = append(atom.Links, atomLink{Rel: "replies", Type: "text/html", Href: entry.Permalink})
+		}
+		if entry.HTML != "" {
+			atom.Content = &atomContent{Type: "html", Value: entry.HTML}
+		} else if entry.Text != "" {
+			atom.Content = &atomContent{Type: "text", Value: entry.Text}
+		}
+		doc.Entries = append(doc.Entries, atom)
+	}
+	return marshal(doc)
+}
+
+// rss description is html, so plain text has to be escaped
+func (entry Entry) content() string {
+	if entry.HTML != "" {
+		return entry.HTML
+	}
+	return html.EscapeString(entry.Text)
+}
+
+func marshal(doc interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+	"reddit_clone/internals/webhooks"
+
+	"github.com/gorilla/mux"
+)
+
+// Site-wide actions; mod log entries of admins have empty category
+type AdminHandler struct {
+	Users      storage.AuthStorage
+	Bans       storage.BanStorage
+	Posts      storage.PostStorage
+	Categories storage.CategoryStorage
+	ModLog     storage.ModLogStorage
+	Webhooks   storage.WebhookStorage
+	Dispatcher *webhooks.Dispatcher
+}
+
+// writes error response if user is not an admin
+func (ah *AdminHandler) checkAdmin(w http.ResponseWriter, r *http.Request, funcName string) (storage.User, bool) {
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/admin.go: %s: cannot get user: %s\n", funcName, err)
+		misc.InternalError(w)
+		return storage.User{}, false
+	}
+	if isAdmin, err := ah.Users.IsAdmin(user.UserID); err != nil {
+		log.Printf("handlers/admin.go: %s: cannot check admin: %s\n", funcName, err)
+		misc.InternalError(w)
+		return storage.User{}, false
+	} else if !isAdmin {
+		http.Error(w, misc.FormMessage("not an admin"), http.StatusForbidden)
+		return storage.User{}, false
+	}
+	return user, true
+}
+
+// takes entry back from mod log when its action failed, entries are written before actions
+func (ah *AdminHandler) unlogAction(entry *storage.ModLogEntry, r *http.Request, funcName string) {
+	if err := ah.ModLog.RemoveEntry(entry.ID, r.Context()); err != nil {
+		log.Printf("handlers/admin.go: %s: cannot remove mod log entry: %s\n", funcName, err)
+	}
+}
+
+func (ah *AdminHandler) Suspend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username, ok := mux.Vars(r)["username"]
+	if !ok {
+		log.Printf("handlers/admin.go: Suspend: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, ok := ah.checkAdmin(w, r, "Suspend")
+	if !ok {
+		return
+	}
+	userID, ok := findUser(w, ah.Users, username, "Suspend")
+	if !ok {
+		return
+	}
+	ban, ok := decodeBan(w, r, user)
+	if !ok {
+		return
+	}
+	target := storage.ModLogTarget{Username: username}
+	entry := newModLogEntry("", user, storage.ModActionSuspend, target, ban.Reason)
+	if err := ah.ModLog.AddEntry(entry, ctx); err != nil {
+		log.Printf("handlers/admin.go: Suspend: cannot add mod log entry: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if err := ah.Bans.Suspend(userID, ban, ctx); err != nil {
+		log.Printf("handlers/admin.go: Suspend: cannot suspend: %s\n", err)
+		ah.unlogAction(entry, r, "Suspend")
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(ban)
+	w.Write(dataRaw)
+}
+
+func (ah *AdminHandler) Unsuspend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username, ok := mux.Vars(r)["username"]
+	if !ok {
+		log.Printf("handlers/admin.go: Unsuspend: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, ok := ah.checkAdmin(w, r, "Unsuspend")
+	if !ok {
+		return
+	}
+	userID, ok := findUser(w, ah.Users, username, "Unsuspend")
+	if !ok {
+		return
+	}
+	target := storage.ModLogTarget{Username: username}
+	entry := newModLogEntry("", user, storage.ModActionUnsuspend, target, "")
+	if err := ah.ModLog.AddEntry(entry, ctx); err != nil {
+		log.Printf("handlers/admin.go: Unsuspend: cannot add mod log entry: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if err := ah.Bans.Unsuspend(userID, ctx); err != nil {
+		log.Printf("handlers/admin.go: Unsuspend: cannot unsuspend: %s\n", err)
+		ah.unlogAction(entry, r, "Unsuspend")
+		misc.InternalError(w)
+		return
+	}
+	w.Write([]byte(misc.FormMessage("success")))
+}
+
+// handles shadow ban and unshadow ban depending on request method
+func (ah *AdminHandler) ShadowBan(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username, ok := mux.Vars(r)["username"]
+	if !ok {
+		log.Printf("handlers/admin.go: ShadowBan: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, ok := ah.checkAdmin(w, r, "ShadowBan")
+	if !ok {
+		return
+	}
+	userID, ok := findUser(w, ah.Users, username, "ShadowBan")
+	if !ok {
+		return
+	}
+	shadowBanned := r.Method != http.MethodDelete
+	action := storage.ModActionUnshadowBan
+	if shadowBanned {
+		action = storage.ModActionShadowBan
+	}
+	target := storage.ModLogTarget{Username: username}
+	entry := newModLogEntry("", user, action, target, "")
+	if err := ah.ModLog.AddEntry(entry, ctx); err != nil {
+		log.Printf("handlers/admin.go: ShadowBan: cannot add mod log entry: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if err := ah.Bans.ShadowBan(userID, shadowBanned, ctx); err != nil {
+		log.Printf("handlers/admin.go: ShadowBan: cannot shadow ban: %s\n", err)
+		ah.unlogAction(entry, r, "ShadowBan")
+		misc.InternalError(w)
+		return
+	}
+	// content is read by its shadow flag, so everything the user made before is flagged too
+	if err := ah.Posts.SetShadow(userID, shadowBanned, ctx); err != nil {
+		log.Printf("handlers/admin.go: ShadowBan: cannot set shadow flag: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	w.Write([]byte(misc.FormMessage("success")))
+}
+
+// site-wide part of mod log, same filters as for categories
+func (ah *AdminHandler) GetModLog(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ah.checkAdmin(w, r, "GetModLog"); !ok {
+		return
+	}
+	errors := misc.NewErrorBuilder()
+	filter := parseModLogFilter(r, "", errors)
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	data, err := ah.ModLog.GetEntries(filter, r.Context())
+	if err != nil {
+		log.Printf("handlers/admin.go: GetModLog: cannot get mod log: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
@@ -20,6 +20,7 @@ const (
 
 type AuthHandler struct {
 	Storage storage.AuthStorage
+	Bans    storage.BanStorage
 }
 
 // aka credentials
@@ -140,18 +141,49 @@ var (
 	key = Token{"user"} // intended as a constat key to get storage.User from requests ctx
 )
 
+// returns false if request has no token at all
+func (ah *AuthHandler) userFromRequest(r *http.Request) (storage.User, bool, error) {
+	authStr := r.Header.Get("Authorization")
+	if authStr == "" {
+		return storage.User{}, false, nil
+	}
+	authParts := strings.Split(authStr, " ")
+	if len(authParts) < 2 || authParts[0] != "Bearer" {
+		return storage.User{}, true, errors.New("bad authorization header")
+	}
+	user, err := ah.Storage.ValidateToken(authParts[1])
+	return user, true, err
+}
+
 // Due to usage of gorilla/mux subrouting there is no need to check if authentification is needed
 func (ah *AuthHandler) CheckAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authStr := r.Header.Get("Authorization")
-		authParts := strings.Split(authStr, " ")
-		if len(authParts) < 2 || authParts[0] != "Bearer" {
+		user, present, err := ah.userFromRequest(r)
+		if !present || err != nil {
 			http.Error(w, misc.FormMessage("unauthorized"), http.StatusUnauthorized)
 			return
 		}
-		user, err := ah.Storage.ValidateToken(authParts[1])
-		if err != nil {
-			http.Error(w, misc.FormMessage("unauthorized"), http.StatusUnauthorized)
+		if ban, err := ah.Bans.GetSuspension(user.UserID, r.Context()); err != nil {
+			log.Printf("handlers/auth.go: CheckAuth: cannot get suspension: %s\n", err)
+			misc.InternalError(w)
+			return
+		} else if ban != nil {
+			errors := misc.NewErrorBuilder()
+			errors.Add("header", "authorization", user.Username, banMessage(ban, "suspended"))
+			http.Error(w, errors.Error(), http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), key, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// For endpoints which work for anonymous users too; request with bad token is treated as anonymous
+func (ah *AuthHandler) OptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, present, err := ah.userFromRequest(r)
+		if !present || err != nil {
+			next.ServeHTTP(w, r)
 			return
 		}
 		ctx := context.WithValue(r.Context(), key, user)
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"reddit_clone/internals/automod"
+	"reddit_clone/internals/storage"
+)
+
+// content removed, flagged or commented by automod has this author
+var autoModeratorUser = storage.User{Username: "AutoModerator"}
+
+// AutoModerator evaluates automod rules of the category and applies triggered actions
+type AutoModerator struct {
+	Posts      storage.PostStorage
+	Categories storage.CategoryStorage
+	Reports    storage.ReportStorage
+	ModLog     storage.ModLogStorage
+	Users      storage.UserStorage
+}
+
+func (am *AutoModerator) rules(category string, ctx context.Context) ([]automod.Rule, error) {
+	data, err := am.Categories.GetCategory(category, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return data.Automod, nil
+}
+
+// fills account age and karma of the author
+func (am *AutoModerator) fillAuthor(subject *automod.Subject, author storage.Author, ctx context.Context) error {
+	profile, err := am.Users.GetProfile(author.Username, ctx)
+	if err != nil {
+		return err
+	}
+	subject.AccountAge = time.Since(profile.Created)
+	subject.Karma = profile.Karma
+	return nil
+}
+
+func (am *AutoModerator) CheckPost(postID string, post *storage.Post, ctx context.Context) error {
+	rules, err := am.rules(post.Category, ctx)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	subject := automod.Subject{
+		Target: automod.TargetPost,
+		Title:  post.Title,
+		Body:   post.Text,
+		URL:    post.URL,
+	}
+	if err := am.fillAuthor(&subject, post.Author, ctx); err != nil {
+		return err
+	}
+	return am.apply(automod.Evaluate(rules, subject), post, postID, "", ctx)
+}
+
+func (am *AutoModerator) CheckComment(postID, commentID string, post *storage.Post, comment *storage.Comment, ctx context.Context) error {
+	rules, err := am.rules(post.Category, ctx)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	subject := automod.Subject{
+		Target: automod.TargetComment,
+		Body:   comment.Body,
+	}
+	if err := am.fillAuthor(&subject, comment.Author, ctx); err != nil {
+		return err
+	}
+	return am.apply(automod.Evaluate(rules, subject), post, postID, commentID, ctx)
+}
+
+// checks rules with report_count; comment is nil if report is about the post
+func (am *AutoModerator) CheckReport(postID, commentID string, post *storage.Post, comment *storage.Comment, ctx context.Context) error {
+	rules, err := am.rules(post.Category, ctx)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	reports, err := am.Reports.CountReports(postID, commentID, ctx)
+	if err != nil {
+		return err
+	}
+	subject := automod.Subject{
+		Target:   automod.TargetPost,
+		Title:    post.Title,
+		Body:     post.Text,
+		URL:      post.URL,
+		Reports:  reports,
+		OnReport: true,
+	}
+	author := post.Author
+	if comment != nil {
+		subject = automod.Subject{
+			Target:   automod.TargetComment,
+			Body:     comment.Body,
+			Reports:  reports,
+			OnReport: true,
+		}
+		author = comment.Author
+	}
+	if err := am.fillAuthor(&subject, author, ctx); err != nil {
+		return err
+	}
+	return am.apply(automod.Evaluate(rules, subject), post, postID, commentID, ctx)
+}
+
+// commentID is empty if actions are about the post
+func (am *AutoModerator) apply(actions []automod.Action, post *storage.Post, postID, commentID string, ctx context.Context) error {
+	removed := false
+	for _, action := range actions {
+		var err error
+		switch action.Type {
+		case automod.ActionRemove:
+			if removed {
+				continue
+			}
+			removed = true
+			err = am.remove(post, postID, commentID, action.Reason, ctx)
+		case automod.ActionFlag:
+			err = am.flag(post, postID, commentID, action.Reason, ctx)
+		case automod.ActionSetFlair:
+			if commentID == "" {
+				err = am.Posts.SetFlair(postID, action.Flair, ctx)
+			}
+		case automod.ActionComment:
+			_, err = am.Posts.MakeComment(postID, "", action.Comment, autoModeratorUser, ctx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removal is logged before it is applied, like removals by moderators
+func (am *AutoModerator) remove(post *storage.Post, postID, commentID, reason string, ctx context.Context) error {
+	action := storage.ModActionRemovePost
+	if commentID != "" {
+		action = storage.ModActionRemoveComment
+	}
+	target := storage.ModLogTarget{PostID: postID, CommentID: commentID}
+	entry := newModLogEntry(post.Category, autoModeratorUser, action, target, reason)
+	if err := am.ModLog.AddEntry(entry, ctx); err != nil {
+		return err
+	}
+	var err error
+	if commentID != "" {
+		err = am.Posts.DeleteComment(postID, commentID, deletionBy(autoModeratorUser, reason), ctx)
+	} else {
+		err = am.Posts.DeletePost(postID, deletionBy(autoModeratorUser, reason), ctx)
+	}
+	if err != nil {
+		if err := am.ModLog.RemoveEntry(entry.ID, ctx); err != nil {
+			log.Printf("handlers/automod.go: remove: cannot remove mod log entry: %s\n", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// flagged content goes to moderation queue as reported by AutoModerator
+func (am *AutoModerator) flag(post *storage.Post, postID, commentID, reason string, ctx context.Context) error {
+	if reported, err := am.Reports.CheckReported(postID, commentID, autoModeratorUser, ctx); err != nil || reported {
+		return err
+	}
+	report := &storage.Report{
+		Category:  post.Category,
+		PostID:    postID,
+		CommentID: commentID,
+		Reporter:  storage.Author{Username: autoModeratorUser.Username, ID: autoModeratorUser.UserID},
+		Reason:    reason,
+		Created:   time.Now(),
+	}
+	return am.Reports.MakeReport(report, ctx)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+)
+
+type BanRequest struct {
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"` // like "72h", empty means permanent
+}
+
+// reads ban from body, writes error response if it is not valid
+func decodeBan(w http.ResponseWriter, r *http.Request, user storage.User) (storage.Ban, bool) {
+	banReq := &BanRequest{}
+	if err := json.NewDecoder(r.Body).Decode(banReq); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return storage.Ban{}, false
+	}
+	ban := storage.Ban{
+		Reason:  banReq.Reason,
+		By:      storage.Author{Username: user.Username, ID: user.UserID},
+		Created: time.Now(),
+	}
+
+	errors := misc.NewErrorBuilder()
+	if len(banReq.Reason) == 0 {
+		errors.Add("body", "reason", "", "cannot be blank")
+	} else if len(banReq.Reason) > maxReasonLen {
+		errors.Add("body", "reason", banReq.Reason, fmt.Sprintf("must be at most %d characters long", maxReasonLen))
+	}
+	if banReq.Duration != "" {
+		if duration, err := time.ParseDuration(banReq.Duration); err != nil || duration <= 0 {
+			errors.Add("body", "duration", banReq.Duration, "must be a positive duration like 72h")
+		} else {
+			until := ban.Created.Add(duration)
+			ban.Until = &until
+		}
+	}
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return storage.Ban{}, false
+	}
+	return ban, true
+}
+
+// what is "suspended" or "banned"
+func banMessage(ban *storage.Ban, what string) string {
+	if ban.Until == nil {
+		return fmt.Sprintf("%s permanently: %s", what, ban.Reason)
+	}
+	return fmt.Sprintf("%s until %s: %s", what, ban.Until.Format(time.RFC3339), ban.Reason)
+}
+
+// returns user id by username or writes 404 if there is no such user
+func findUser(w http.ResponseWriter, users storage.AuthStorage, username, funcName string) (string, bool) {
+	if exist, err := users.IsUserExist(username); err != nil {
+		log.Printf("handlers/bans.go: %s: cannot check user existance: %s\n", funcName, err)
+		misc.InternalError(w)
+		return "", false
+	} else if !exist {
+		http.Error(w, misc.FormMessage("user not found"), http.StatusNotFound)
+		return "", false
+	}
+	userID, err := users.GetUserID(username)
+	if err != nil {
+		log.Printf("handlers/bans.go: %s: cannot get user id: %s\n", funcName, err)
+		misc.InternalError(w)
+		return "", false
+	}
+	return userID, true
+}
+
+// writes 403 if user is banned in category, returns false if request should not go further
+func checkCategoryBan(w http.ResponseWriter, r *http.Request, bans storage.BanStorage, category string, user storage.User, location, funcName string) bool {
+	ban, err := bans.GetCategoryBan(category, user.UserID, r.Context())
+	if err != nil {
+		log.Printf("handlers/bans.go: %s: cannot get category ban: %s\n", funcName, err)
+		misc.InternalError(w)
+		return false
+	} else if ban != nil {
+		errors := misc.NewErrorBuilder()
+		errors.Add(location, "category", category, banMessage(ban, "banned"))
+		http.Error(w, errors.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
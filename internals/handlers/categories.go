@@ -0,0 +1,486 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"reddit_clone/internals/automod"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxCategoryNameLen    = 32
+	maxCategoryDescLen    = 512
+	maxCategoryRulesCount = 15
+)
+
+type CategoryHandler struct {
+	Storage storage.CategoryStorage
+	Users   storage.AuthStorage
+	ModLog  storage.ModLogStorage
+}
+
+type Moderator struct {
+	Username string `json:"username"`
+}
+
+func isPossibleCategory(category *storage.NewCategory, errors *misc.ErrorBuilder) {
+	if len(category.Name) == 0 {
+		errors.Add("body", "name", "", "cannot be blank")
+	} else if len(category.Name) > maxCategoryNameLen {
+		errStr := fmt.Sprintf("must be at most %d characters long", maxCategoryNameLen)
+		errors.Add("body", "name", category.Name, errStr)
+	} else if !misc.IsValidCategoryName(category.Name) {
+		errors.Add("body", "name", category.Name, "contains invalid characters")
+	}
+
+	if len(category.Description) > maxCategoryDescLen {
+		errStr := fmt.Sprintf("must be at most %d characters long", maxCategoryDescLen)
+		errors.Add("body", "description", category.Description, errStr)
+	}
+
+	if len(category.Rules) > maxCategoryRulesCount {
+		errStr := fmt.Sprintf("must be at most %d rules", maxCategoryRulesCount)
+		errors.Add("body", "rules", "", errStr)
+	}
+	for _, rule := range category.Rules {
+		if len(rule) == 0 {
+			errors.Add("body", "rules", "", "rule cannot be blank")
+		} else if misc.IsBorderSpace(rule) {
+			errors.Add("body", "rules", rule, "rule cannot start or end with whitespace")
+		}
+	}
+}
+
+func (ch *CategoryHandler) GetCategories(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	data, err := ch.Storage.GetCategories(ctx)
+	if err != nil {
+		log.Printf("handlers/categories.go: GetCategories: cannot get categories: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+func (ch *CategoryHandler) GetCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name, ok := mux.Vars(r)["category"]
+	if !ok {
+		log.Printf("handlers/categories.go: GetCategory: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	if exist, err := ch.Storage.CheckCategoryExist(name, ctx); err != nil {
+		log.Printf("handlers/categories.go: GetCategory: cannot check category existance: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !exist {
+		http.Error(w, misc.FormMessage("category not found"), http.StatusNotFound)
+		return
+	}
+	data, err := ch.Storage.GetCategory(name, ctx)
+	if err != nil {
+		log.Printf("handlers/categories.go: GetCategory: cannot get category: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+func (ch *CategoryHandler) MakeCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	category := &storage.NewCategory{}
+	if err := json.NewDecoder(r.Body).Decode(category); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+
+	errors := misc.NewErrorBuilder()
+	isPossibleCategory(category, errors)
+	if exist, err := ch.Storage.CheckCategoryExist(category.Name, ctx); err != nil {
+		log.Printf("handlers/categories.go: MakeCategory: cannot check category existance: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if exist {
+		errors.Add("body", "name", category.Name, "already exists")
+	}
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/categories.go: MakeCategory: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if err := ch.Storage.CreateCategory(category, user, ctx); mongo.IsDuplicateKeyError(err) {
+		// same name was taken between the check above and the insert
+		errors.Add("body", "name", category.Name, "already exists")
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		log.Printf("handlers/categories.go: MakeCategory: cannot create category: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ch.Storage.GetCategory(category.Name, ctx)
+	if err != nil {
+		log.Printf("handlers/categories.go: MakeCategory: cannot get category: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(dataRaw)
+}
+
+//-------------------------------------Subscriptions-----------------------------------//
+
+func (ch *CategoryHandler) GetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/categories.go: GetSubscriptions: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ch.Storage.GetSubscriptions(user, ctx)
+	if err != nil {
+		log.Printf("handlers/categories.go: GetSubscriptions: cannot get subscriptions: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+// handles both subscribe and unsubscribe depending on the last part of the path
+func (ch *CategoryHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name, ok := mux.Vars(r)["category"]
+	if !ok {
+		log.Printf("handlers/categories.go: Subscribe: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	if exist, err := ch.Storage.CheckCategoryExist(name, ctx); err != nil {
+		log.Printf("handlers/categories.go: Subscribe: cannot check category existance: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !exist {
+		http.Error(w, misc.FormMessage("category not found"), http.StatusNotFound)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/categories.go: Subscribe: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	switch path.Base(r.URL.Path) {
+	case "subscribe":
+		err = ch.Storage.Subscribe(name, user, ctx)
+	case "unsubscribe":
+		err = ch.Storage.Unsubscribe(name, user, ctx)
+	}
+	if err != nil {
+		log.Printf("handlers/categories.go: Subscribe: cannot change subscription: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+
+	data, err := ch.Storage.GetSubscriptions(user, ctx)
+	if err != nil {
+		log.Printf("handlers/categories.go: Subscribe: cannot get subscriptions: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+//-------------------------------------Moderators--------------------------------------//
+
+// gets category from route and checks that user owns it (or is an admin if it has no owner); writes error response if something is wrong
+func (ch *CategoryHandler) ownedCategory(w http.ResponseWriter, r *http.Request, funcName string) (string, storage.User, bool) {
+	ctx := r.Context()
+	name, ok := mux.Vars(r)["category"]
+	if !ok {
+		log.Printf("handlers/categories.go: %s: bad routing: %s\n", funcName, r.URL.Path)
+		misc.InternalError(w)
+		return "", storage.User{}, false
+	}
+	if exist, err := ch.Storage.CheckCategoryExist(name, ctx); err != nil {
+		log.Printf("handlers/categories.go: %s: cannot check category existance: %s\n", funcName, err)
+		misc.InternalError(w)
+		return "", storage.User{}, false
+	} else if !exist {
+		http.Error(w, misc.FormMessage("category not found"), http.StatusNotFound)
+		return "", storage.User{}, false
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/categories.go: %s: cannot get user: %s\n", funcName, err)
+		misc.InternalError(w)
+		return "", storage.User{}, false
+	}
+	category, err := ch.Storage.GetCategory(name, ctx)
+	if err != nil {
+		log.Printf("handlers/categories.go: %s: cannot get category: %s\n", funcName, err)
+		misc.InternalError(w)
+		return "", storage.User{}, false
+	} else if category.Owner.ID != "" {
+		if category.Owner.ID != user.UserID {
+			http.Error(w, misc.FormMessage("not an owner"), http.StatusForbidden)
+			return "", storage.User{}, false
+		}
+		return name, user, true
+	}
+	// categories without an owner (seeded ones) are managed by admins
+	if admin, err := ch.Users.IsAdmin(user.UserID); err != nil {
+		log.Printf("handlers/categories.go: %s: cannot check admin: %s\n", funcName, err)
+		misc.InternalError(w)
+		return "", storage.User{}, false
+	} else if !admin {
+		http.Error(w, misc.FormMessage("not an owner"), http.StatusForbidden)
+		return "", storage.User{}, false
+	}
+	return name, user, true
+}
+
+// returns moderator by username or writes error response if there is no such user
+func (ch *CategoryHandler) findModerator(w http.ResponseWriter, username, funcName string) (storage.Author, bool) {
+	if exist, err := ch.Users.IsUserExist(username); err != nil {
+		log.Printf("handlers/categories.go: %s: cannot check user existance: %s\n", funcName, err)
+		misc.InternalError(w)
+		return storage.Author{}, false
+	} else if !exist {
+		http.Error(w, misc.FormError("body", "username", username, "user not exist"), http.StatusUnprocessableEntity)
+		return storage.Author{}, false
+	}
+	userID, err := ch.Users.GetUserID(username)
+	if err != nil {
+		log.Printf("handlers/categories.go: %s: cannot get user id: %s\n", funcName, err)
+		misc.InternalError(w)
+		return storage.Author{}, false
+	}
+	return storage.Author{Username: username, ID: userID}, true
+}
+
+// logs action before it is applied; entry is returned to be taken back if the action fails
+func (ch *CategoryHandler) logAction(category string, user storage.User, action string, moderator storage.Author, r *http.Request) (*storage.ModLogEntry, error) {
+	target := storage.ModLogTarget{Username: moderator.Username}
+	entry := newModLogEntry(category, user, action, target, "")
+	return entry, ch.ModLog.AddEntry(entry, r.Context())
+}
+
+func (ch *CategoryHandler) writeCategory(w http.ResponseWriter, r *http.Request, name, funcName string) {
+	data, err := ch.Storage.GetCategory(name, r.Context())
+	if err != nil {
+		log.Printf("handlers/categories.go: %s: cannot get category: %s\n", funcName, err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+func (ch *CategoryHandler) AddModerator(w http.ResponseWriter, r *http.Request) {
+	name, user, ok := ch.ownedCategory(w, r, "AddModerator")
+	if !ok {
+		return
+	}
+	moderator := &Moderator{}
+	if err := json.NewDecoder(r.Body).Decode(moderator); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	author, ok := ch.findModerator(w, moderator.Username, "AddModerator")
+	if !ok {
+		return
+	}
+	entry, err := ch.logAction(name, user, storage.ModActionAddModerator, author, r)
+	if err != nil {
+		log.Printf("handlers/categories.go: AddModerator: cannot add mod log entry: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if err := ch.Storage.AddModerator(name, author, r.Context()); err != nil {
+		log.Printf("handlers/categories.go: AddModerator: cannot add moderator: %s\n", err)
+		if err := ch.ModLog.RemoveEntry(entry.ID, r.Context()); err != nil {
+			log.Printf("handlers/categories.go: AddModerator: cannot remove mod log entry: %s\n", err)
+		}
+		misc.InternalError(w)
+		return
+	}
+	ch.writeCategory(w, r, name, "AddModerator")
+}
+
+func (ch *CategoryHandler) RemoveModerator(w http.ResponseWriter, r *http.Request) {
+	name, user, ok := ch.ownedCategory(w, r, "RemoveModerator")
+	if !ok {
+		return
+	}
+	author, ok := ch.findModerator(w, mux.Vars(r)["username"], "RemoveModerator")
+	if !ok {
+		return
+	}
+	entry, err := ch.logAction(name, user, storage.ModActionRemoveModerator, author, r)
+	if err != nil {
+		log.Printf("handlers/categories.go: RemoveModerator: cannot add mod log entry: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if err := ch.Storage.RemoveModerator(name, author, r.Context()); err != nil {
+		log.Printf("handlers/categories.go: RemoveModerator: cannot remove moderator: %s\n", err)
+		if err := ch.ModLog.RemoveEntry(entry.ID, r.Context()); err != nil {
+			log.Printf("handlers/categories.go: RemoveModerator: cannot remove mod log entry: %s\n", err)
+		}
+		misc.InternalError(w)
+		return
+	}
+	ch.writeCategory(w, r, name, "RemoveModerator")
+}
+
+//-------------------------------------Automod-----------------------------------------//
+
+// gets category from route and checks that user moderates it; writes error response if something is wrong
+func (ch *CategoryHandler) moderatedCategory(w http.ResponseWriter, r *http.Request, funcName string) (string, bool) {
+	ctx := r.Context()
+	name, ok := mux.Vars(r)["category"]
+	if !ok {
+		log.Printf("handlers/categories.go: %s: bad routing: %s\n", funcName, r.URL.Path)
+		misc.InternalError(w)
+		return "", false
+	}
+	if exist, err := ch.Storage.CheckCategoryExist(name, ctx); err != nil {
+		log.Printf("handlers/categories.go: %s: cannot check category existance: %s\n", funcName, err)
+		misc.InternalError(w)
+		return "", false
+	} else if !exist {
+		http.Error(w, misc.FormMessage("category not found"), http.StatusNotFound)
+		return "", false
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/categories.go: %s: cannot get user: %s\n", funcName, err)
+		misc.InternalError(w)
+		return "", false
+	}
+	if isModerator, err := ch.Storage.IsModerator(name, user, ctx); err != nil {
+		log.Printf("handlers/categories.go: %s: cannot check moderator: %s\n", funcName, err)
+		misc.InternalError(w)
+		return "", false
+	} else if !isModerator {
+		http.Error(w, misc.FormMessage("not a moderator"), http.StatusForbidden)
+		return "", false
+	}
+	return name, true
+}
+
+func (ch *CategoryHandler) writeAutomod(w http.ResponseWriter, r *http.Request, name, funcName string) {
+	data, err := ch.Storage.GetCategory(name, r.Context())
+	if err != nil {
+		log.Printf("handlers/categories.go: %s: cannot get category: %s\n", funcName, err)
+		misc.InternalError(w)
+		return
+	}
+	rules := data.Automod
+	if rules == nil {
+		rules = []automod.Rule{}
+	}
+	dataRaw, _ := json.Marshal(rules)
+	w.Write(dataRaw)
+}
+
+func (ch *CategoryHandler) GetAutomod(w http.ResponseWriter, r *http.Request) {
+	name, ok := ch.moderatedCategory(w, r, "GetAutomod")
+	if !ok {
+		return
+	}
+	ch.writeAutomod(w, r, name, "GetAutomod")
+}
+
+// replaces all automod rules of the category
+func (ch *CategoryHandler) SetAutomod(w http.ResponseWriter, r *http.Request) {
+	name, ok := ch.moderatedCategory(w, r, "SetAutomod")
+	if !ok {
+		return
+	}
+	rules := []automod.Rule{}
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	if err := automod.Validate(rules); err != nil {
+		http.Error(w, misc.FormError("body", "rules", "", err.Error()), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := ch.Storage.SetAutomod(name, rules, r.Context()); err != nil {
+		log.Printf("handlers/categories.go: SetAutomod: cannot set automod rules: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	ch.writeAutomod(w, r, name, "SetAutomod")
+}
+
+//-------------------------------------Domains-----------------------------------------//
+
+// domain should be a bare host name like example.com
+func isPossibleDomain(domain string) bool {
+	return len(domain) > 0 && !strings.ContainsAny(domain, "/:@?# \t") && domain == strings.ToLower(domain)
+}
+
+func (ch *CategoryHandler) SetDomains(w http.ResponseWriter, r *http.Request) {
+	name, ok := ch.moderatedCategory(w, r, "SetDomains")
+	if !ok {
+		return
+	}
+	domains := misc.DomainPolicy{}
+	if err := json.NewDecoder(r.Body).Decode(&domains); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	if domains.Allow == nil {
+		domains.Allow = []string{}
+	}
+	if domains.Deny == nil {
+		domains.Deny = []string{}
+	}
+
+	errors := misc.NewErrorBuilder()
+	for _, domain := range domains.Allow {
+		if !isPossibleDomain(domain) {
+			errors.Add("body", "allow", domain, "must be a lowercase domain like example.com")
+		}
+	}
+	for _, domain := range domains.Deny {
+		if !isPossibleDomain(domain) {
+			errors.Add("body", "deny", domain, "must be a lowercase domain like example.com")
+		}
+	}
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := ch.Storage.SetDomains(name, domains, r.Context()); err != nil {
+		log.Printf("handlers/categories.go: SetDomains: cannot set domains: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	ch.writeCategory(w, r, name, "SetDomains")
+}
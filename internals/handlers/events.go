@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"reddit_clone/internals/events"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+)
+
+const (
+	maxEventTopics    = 50
+	heartbeatInterval = 30 * time.Second
+)
+
+var postIDRe = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// publishing is best effort: events only tell clients what to re-fetch, so errors are just logged
+func publish(broker events.Broker, event events.Event) {
+	if broker == nil {
+		return
+	}
+	if err := broker.Publish(event); err != nil {
+		log.Printf("handlers/events.go: publish: %s\n", err)
+	}
+}
+
+// publishes event to subscribers of the post and of its category
+func publishPost(broker events.Broker, eventType string, post *storage.Post, postID, commentID string) {
+	event := events.Event{Type: eventType, PostID: postID, CommentID: commentID, Category: post.Category}
+	event.Topic = events.PostTopic(postID)
+	publish(broker, event)
+	event.Topic = events.CategoryTopic(post.Category)
+	publish(broker, event)
+}
+
+// same as publishPost, but finds the post first
+func (ph *PostHandler) publish(eventType, postID, commentID string, ctx context.Context) {
+	post, err := ph.Storage.FindPost(postID, ctx)
+	if err != nil || post == nil {
+		log.Printf("handlers/events.go: publish: cannot find post: %v\n", err)
+		return
+	}
+	publishPost(ph.Events, eventType, post, postID, commentID)
+}
+
+// announces new post or comment, if others can see it
+func (ph *PostHandler) publishCreated(eventType, postID, commentID string, ctx context.Context) {
+	post, err := ph.Storage.FindPost(postID, ctx)
+	if err != nil || post == nil {
+		log.Printf("handlers/events.go: publishCreated: cannot find post: %v\n", err)
+		return
+	}
+	if _, ok := visibleCreation(post, commentID); ok {
+		publishPost(ph.Events, eventType, post, postID, commentID)
+	}
+}
+
+// finds the new comment (nil for new post) and checks that others can see the new content:
+// content removed right away by automod or made by a shadow banned user is announced
+// neither by events nor by webhooks, or subscribers would learn ids the api hides from them
+func visibleCreation(post *storage.Post, commentID string) (*storage.Comment, bool) {
+	if post.Deleted != nil || post.Shadow {
+		return nil, false
+	}
+	if commentID == "" {
+		return nil, true
+	}
+	comment := postComment(post, commentID)
+	if comment == nil || comment.Deleted != nil || comment.Shadow {
+		return nil, false
+	}
+	return comment, true
+}
+
+// returns comment of the post with given id or nil
+func postComment(post *storage.Post, commentID string) *storage.Comment {
+	for i := range post.Comments {
+		if hex.EncodeToString(post.Comments[i].ID) == commentID {
+			return &post.Comments[i]
+		}
+	}
+	return nil
+}
+
+type EventHandler struct {
+	Broker events.Broker
+}
+
+// Server-Sent Events stream, topics are chosen with query:
+// ?post=<id>&category=<name>&inbox=true, post and category can be repeated;
+// inbox needs the user to be logged in
+func (eh *EventHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	errors := misc.NewErrorBuilder()
+	topics := make([]string, 0, 4)
+	for _, postID := range query["post"] {
+		if !postIDRe.MatchString(postID) {
+			errors.Add("query", "post", postID, "is not a valid id")
+		}
+		topics = append(topics, events.PostTopic(postID))
+	}
+	for _, category := range query["category"] {
+		if !misc.IsValidCategoryName(category) {
+			errors.Add("query", "category", category, "is not a valid category name")
+		}
+		topics = append(topics, events.CategoryTopic(category))
+	}
+	if query.Get("inbox") == "true" {
+		user, err := GetUser(r)
+		if err != nil {
+			http.Error(w, misc.FormMessage("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+		topics = append(topics, events.InboxTopic(user.UserID))
+	}
+	if len(topics) == 0 {
+		errors.Add("query", "post", "", "at least one of post, category or inbox is required")
+	} else if len(topics) > maxEventTopics {
+		errors.Add("query", "post", "", fmt.Sprintf("at most %d topics are allowed", maxEventTopics))
+	}
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Printf("handlers/events.go: Stream: response writer cannot flush\n")
+		misc.InternalError(w)
+		return
+	}
+
+	stream, cancel := eh.Broker.Subscribe(topics)
+	defer cancel()
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("x-accel-buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
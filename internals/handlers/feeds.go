@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"reddit_clone/internals/feeds"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"github.com/gorilla/mux"
+)
+
+var newestEntries = storage.ListOptions{Sort: storage.SortNew, Limit: 50}
+
+// feeds are read by anonymous feed readers, so they get what anonymous viewer gets
+func (ph *PostHandler) FeedPosts(w http.ResponseWriter, r *http.Request) {
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/feeds.go: FeedPosts: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	posts, err := ph.Storage.GetFeed(nil, newestEntries, viewer, r.Context())
+	if err != nil {
+		log.Printf("handlers/feeds.go: FeedPosts: cannot get posts: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	base := baseURL(r)
+	feed := feeds.Feed{
+		Title:       "All posts",
+		Description: "Newest posts from all categories",
+		Link:        base + "/",
+		Self:        base + r.URL.Path,
+	}
+	writeFeed(w, r, feed, posts, feeds.RSS, "application/rss+xml", "FeedPosts")
+}
+
+func (ph *PostHandler) FeedCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	category, ok := mux.Vars(r)["category"]
+	if !ok {
+		log.Printf("handlers/feeds.go: FeedCategory: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	if exist, err := ph.Categories.CheckCategoryExist(category, ctx); err != nil {
+		log.Printf("handlers/feeds.go: FeedCategory: cannot check category existance: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !exist {
+		http.Error(w, misc.FormMessage("category not found"), http.StatusNotFound)
+		return
+	}
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/feeds.go: FeedCategory: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	posts, err := ph.Storage.GetFeed([]string{category}, newestEntries, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/feeds.go: FeedCategory: cannot get posts: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	base := baseURL(r)
+	feed := feeds.Feed{
+		Title:       category,
+		Description: "Newest posts in " + category,
+		Link:        base + "/a/" + category,
+		Self:        base + r.URL.Path,
+	}
+	writeFeed(w, r, feed, posts, feeds.Atom, "application/atom+xml", "FeedCategory")
+}
+
+func (ph *PostHandler) FeedUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username, ok := mux.Vars(r)["username"]
+	if !ok {
+		log.Printf("handlers/feeds.go: FeedUser: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	if exist, err := ph.Storage.CheckUserExist(username, ctx); err != nil {
+		log.Printf("handlers/feeds.go: FeedUser: cannot check user existance: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !exist {
+		http.Error(w, misc.FormMessage("user not exist"), http.StatusNotFound)
+		return
+	}
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/feeds.go: FeedUser: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	posts, err := ph.Storage.GetFeedByUsername(username, newestEntries, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/feeds.go: FeedUser: cannot get posts: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	base := baseURL(r)
+	feed := feeds.Feed{
+		Title:       username,
+		Description: "Newest posts of " + username,
+		Link:        base + "/u/" + username,
+		Self:        base + r.URL.Path,
+	}
+	writeFeed(w, r, feed, posts, feeds.Atom, "application/atom+xml", "FeedUser")
+}
+
+// scheme and host the request came to, proxies may tell the scheme with x-forwarded-proto
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("x-forwarded-proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// posts come newest first; feed changes only when the set of posts does (posts are never edited),
+// so etag is a hash of their ids and readers polling with if-none-match get 304 without rendering
+func writeFeed(w http.ResponseWriter, r *http.Request, feed feeds.Feed, posts []*storage.Post,
+	render func(feeds.Feed) ([]byte, error), contentType, funcName string) {
+	ids := sha256.New()
+	for _, post := range posts {
+		ids.Write(post.ID)
+	}
+	etag := `"` + hex.EncodeToString(ids.Sum(nil)[:16]) + `"`
+	feed.Updated = time.Unix(0, 0).UTC()
+	if len(posts) > 0 {
+		if created, err := time.Parse(time.RFC3339, posts[0].Created); err == nil {
+			feed.Updated = created
+		}
+	}
+	w.Header().Set("etag", etag)
+	w.Header().Set("last-modified", feed.Updated.UTC().Format(http.TimeFormat))
+	w.Header().Set("cache-control", "no-cache")
+	if etagMatches(r.Header.Get("if-none-match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	base := baseURL(r)
+	for _, post := range posts {
+		published, err := time.Parse(time.RFC3339, post.Created)
+		if err != nil {
+			log.Printf("handlers/feeds.go: %s: bad post creation time: %s\n", funcName, err)
+		}
+		postID := hex.EncodeToString(post.ID)
+		entry := feeds.Entry{
+			ID:        postID,
+			Title:     post.Title,
+			Permalink: base + "/a/" + post.Category + "/" + postID,
+			Author:    post.Author.Username,
+			Category:  post.Category,
+			Text:      post.Text,
+			HTML:      post.TextHTML,
+			Published: published,
+		}
+		entry.Link = entry.Permalink
+		if post.Type == "link" {
+			entry.Link = post.URL
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	data, err := render(feed)
+	if err != nil {
+		log.Printf("handlers/feeds.go: %s: cannot render feed: %s\n", funcName, err)
+		misc.InternalError(w)
+		return
+	}
+	w.Header().Set("content-type", contentType+"; charset=utf-8")
+	w.Write(data)
+}
+
+// if-none-match is "*" or a list of entity tags, which are compared weakly (RFC 7232, section 3.2)
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
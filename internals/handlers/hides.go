@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"github.com/gorilla/mux"
+)
+
+//--------------------------------------Hidden posts-----------------------------------//
+
+// hides or unhides post, hidden posts are not listed for the user but still can be opened
+func (ph *PostHandler) HidePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, ok := mux.Vars(r)["post_id"]
+	if !ok {
+		log.Printf("handlers/hides.go: HidePost: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/hides.go: HidePost: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	switch path.Base(r.URL.Path) {
+	case "hide":
+		if exist, err := ph.Storage.CheckPostExist(postID, ctx); err != nil {
+			log.Printf("handlers/hides.go: HidePost: cannot check post existance: %s\n", err)
+			misc.InternalError(w)
+			return
+		} else if !exist {
+			http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
+			return
+		}
+		err = ph.Hides.HidePost(postID, user, ctx)
+	case "unhide":
+		err = ph.Hides.UnhidePost(postID, user, ctx)
+	}
+	if err != nil {
+		log.Printf("handlers/hides.go: HidePost: cannot change hidden posts: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	w.Write([]byte(misc.FormMessage("success")))
+}
+
+func (ph *PostHandler) GetHiddenPosts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/hides.go: GetHiddenPosts: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	postIDs, err := ph.Hides.GetHiddenPosts(user.UserID, ctx)
+	if err != nil {
+		log.Printf("handlers/hides.go: GetHiddenPosts: cannot get hidden posts: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/hides.go: GetHiddenPosts: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPostsByIDs(postIDs, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/hides.go: GetHiddenPosts: cannot get posts: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+//----------------------------------------Blocks---------------------------------------//
+
+// blocks or unblocks user; posts and comments of blocked user are filtered out for the blocker,
+// and blocked user cannot comment on posts of the blocker
+func (uh *UserHandler) Block(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username, ok := mux.Vars(r)["username"]
+	if !ok {
+		log.Printf("handlers/hides.go: Block: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/hides.go: Block: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	blockedID, ok := findUser(w, uh.Users, username, "Block")
+	if !ok {
+		return
+	}
+	if blockedID == user.UserID {
+		http.Error(w, misc.FormError("path", "username", username, "cannot block yourself"), http.StatusUnprocessableEntity)
+		return
+	}
+	switch path.Base(r.URL.Path) {
+	case "block":
+		err = uh.Hides.Block(storage.Author{Username: username, ID: blockedID}, user, ctx)
+	case "unblock":
+		err = uh.Hides.Unblock(blockedID, user, ctx)
+	}
+	if err != nil {
+		log.Printf("handlers/hides.go: Block: cannot change blocks: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	uh.writeBlocked(w, user, "Block", ctx)
+}
+
+func (uh *UserHandler) GetBlocked(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/hides.go: GetBlocked: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	uh.writeBlocked(w, user, "GetBlocked", r.Context())
+}
+
+func (uh *UserHandler) writeBlocked(w http.ResponseWriter, user storage.User, funcName string, ctx context.Context) {
+	data, err := uh.Hides.GetBlocked(user.UserID, ctx)
+	if err != nil {
+		log.Printf("handlers/hides.go: %s: cannot get blocked users: %s\n", funcName, err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
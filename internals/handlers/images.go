@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"reddit_clone/internals/images"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxImageSize    = 10 << 20
+	maxFormSize     = maxImageSize + 64<<10 // room for other form fields
+	maxFormMemory   = 1 << 20
+	thumbnailSuffix = "_thumb"
+	mediaPrefix     = "/media/"
+)
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("content-type"))
+	return mediaType == "multipart/form-data"
+}
+
+// image posts come as multipart form with the same fields json posts have and an image file;
+// returns image bytes or writes error response and returns false
+func readImagePost(w http.ResponseWriter, r *http.Request, post *storage.NewPost) ([]byte, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	if err := r.ParseMultipartForm(maxFormMemory); err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			http.Error(w, misc.FormMessage("image is too large"), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		}
+		return nil, false
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	post.Category = r.FormValue("category")
+	post.Type = r.FormValue("type")
+	post.Title = r.FormValue("title")
+	post.Text = r.FormValue("text")
+	post.URL = r.FormValue("url")
+	post.Force = r.FormValue("force") == "true"
+
+	file, header, err := r.FormFile("image")
+	if err == http.ErrMissingFile {
+		return nil, true
+	} else if err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return nil, false
+	}
+	defer file.Close()
+	if header.Size > maxImageSize {
+		http.Error(w, misc.FormMessage("image is too large"), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxImageSize))
+	if err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+// image and its thumbnail get one random key, so their urls cannot be guessed from the post
+func (ph *PostHandler) storeImage(image *images.Image, ctx context.Context) (*storage.Image, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	name := hex.EncodeToString(random)
+	stored := &storage.Image{
+		ContentType:  image.ContentType,
+		Width:        image.Width,
+		Height:       image.Height,
+		Size:         len(image.Data),
+		Key:          name + images.Extensions[image.ContentType],
+		ThumbnailKey: name + thumbnailSuffix + ".jpg",
+	}
+	stored.URL = mediaPrefix + stored.Key
+	stored.ThumbnailURL = mediaPrefix + stored.ThumbnailKey
+
+	if err := ph.Blobs.Put(stored.Key, image.ContentType, image.Data, ctx); err != nil {
+		return nil, err
+	}
+	if err := ph.Blobs.Put(stored.ThumbnailKey, "image/jpeg", image.Thumb, ctx); err != nil {
+		ph.deleteImage(stored, ctx)
+		return nil, err
+	}
+	return stored, nil
+}
+
+func (ph *PostHandler) deleteImage(image *storage.Image, ctx context.Context) {
+	for _, key := range []string{image.Key, image.ThumbnailKey} {
+		if err := ph.Blobs.Delete(key, ctx); err != nil {
+			log.Printf("handlers/images.go: deleteImage: cannot delete blob %s: %s\n", key, err)
+		}
+	}
+}
+
+//----------------------------------------Media----------------------------------------//
+
+type MediaHandler struct {
+	Blobs storage.BlobStore
+	Posts storage.PostStorage
+}
+
+var mediaTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+	".gif": "image/gif",
+}
+
+// images of deleted and removed posts are not served, though their blobs stay until purge;
+// blobs never change, but caches must not keep them long, or removal would not take them offline
+func (mh *MediaHandler) GetMedia(w http.ResponseWriter, r *http.Request) {
+	key, ok := mux.Vars(r)["key"]
+	if !ok {
+		log.Printf("handlers/images.go: GetMedia: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	contentType, ok := mediaTypes[path.Ext(key)]
+	if !ok {
+		http.Error(w, misc.FormMessage("not found"), http.StatusNotFound)
+		return
+	}
+	if post, err := mh.Posts.FindImagePost(key, r.Context()); err != nil {
+		log.Printf("handlers/images.go: GetMedia: cannot find post: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if post == nil || post.Deleted != nil {
+		http.Error(w, misc.FormMessage("not found"), http.StatusNotFound)
+		return
+	}
+	blob, err := mh.Blobs.Get(key, r.Context())
+	if err != nil {
+		log.Printf("handlers/images.go: GetMedia: cannot get blob: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if blob == nil {
+		http.Error(w, misc.FormMessage("not found"), http.StatusNotFound)
+		return
+	}
+	defer blob.Close()
+	w.Header().Set("content-type", contentType)
+	w.Header().Set("cache-control", "public, max-age=600")
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.Header().Set("content-security-policy", "default-src 'none'")
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("handlers/images.go: GetMedia: cannot write blob: %s\n", err)
+	}
+}
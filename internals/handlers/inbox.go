@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"reddit_clone/internals/events"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxMentions = 10
+
+// notifies post author about top-level comments, parent comment author about replies, and mentioned users;
+// nobody is notified about their own comments or by users they blocked,
+// comments of shadow banned users are seen by nobody, so they notify nobody
+func (ph *PostHandler) notifyComment(postID string, post *storage.Post, parent *storage.Comment, commentID, body string, user storage.User, ctx context.Context) error {
+	if shadowBanned, err := ph.Bans.IsShadowBanned(user.UserID, ctx); err != nil || shadowBanned {
+		return err
+	}
+
+	kinds := make(map[string]string)
+	recipients := make([]string, 0, 2)
+	add := func(userID, kind string) {
+		if _, ok := kinds[userID]; ok || userID == "" || userID == user.UserID {
+			return
+		}
+		kinds[userID] = kind
+		recipients = append(recipients, userID)
+	}
+	if parent != nil {
+		add(parent.Author.ID, storage.NotificationCommentReply)
+	} else {
+		add(post.Author.ID, storage.NotificationPostReply)
+	}
+	for _, username := range misc.Mentions(body, maxMentions) {
+		if exist, err := ph.Users.IsUserExist(username); err != nil {
+			return err
+		} else if !exist {
+			continue
+		}
+		userID, err := ph.Users.GetUserID(username)
+		if err != nil {
+			return err
+		}
+		add(userID, storage.NotificationMention)
+	}
+
+	notifications := make([]*storage.Notification, 0, len(recipients))
+	for _, userID := range recipients {
+		if blocked, err := ph.Hides.IsBlocked(user.UserID, userID, ctx); err != nil {
+			return err
+		} else if blocked {
+			continue
+		}
+		notifications = append(notifications, &storage.Notification{
+			UserID:    userID,
+			Type:      kinds[userID],
+			From:      storage.Author{Username: user.Username, ID: user.UserID},
+			PostID:    postID,
+			Category:  post.Category,
+			CommentID: commentID,
+			Created:   time.Now(),
+		})
+	}
+	if err := ph.Notifications.AddNotifications(notifications, ctx); err != nil {
+		return err
+	}
+	for _, notification := range notifications {
+		publish(ph.Events, events.Event{
+			Type:      events.Notification,
+			Topic:     events.InboxTopic(notification.UserID),
+			PostID:    postID,
+			CommentID: commentID,
+			Category:  post.Category,
+		})
+	}
+	return nil
+}
+
+//-----------------------------------------Inbox---------------------------------------//
+
+type InboxHandler struct {
+	Storage storage.NotificationStorage
+	Hides   storage.HideStorage
+}
+
+type Inbox struct {
+	Unread        int                     `json:"unread"`
+	Notifications []*storage.Notification `json:"notifications"`
+}
+
+type MarkReadRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// ?unread=true lists only unread notifications
+func (ih *InboxHandler) GetInbox(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	errors := misc.NewErrorBuilder()
+	opts := parseListOptions(r, errors)
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/inbox.go: GetInbox: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	blocked, err := blockedIDs(ih.Hides, user.UserID, ctx)
+	if err != nil {
+		log.Printf("handlers/inbox.go: GetInbox: cannot get blocked users: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	notifications, err := ih.Storage.GetNotifications(user.UserID, blocked, unreadOnly, opts, ctx)
+	if err != nil {
+		log.Printf("handlers/inbox.go: GetInbox: cannot get notifications: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	unread, err := ih.Storage.CountUnread(user.UserID, blocked, ctx)
+	if err != nil {
+		log.Printf("handlers/inbox.go: GetInbox: cannot count unread: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(Inbox{Unread: unread, Notifications: notifications})
+	w.Write(dataRaw)
+}
+
+// marks given notifications as read, empty body marks all of them
+func (ih *InboxHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := &MarkReadRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	errors := misc.NewErrorBuilder()
+	for _, id := range request.IDs {
+		if !primitive.IsValidObjectID(id) {
+			errors.Add("body", "ids", id, "is not a valid id")
+		}
+	}
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/inbox.go: MarkRead: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if err := ih.Storage.MarkRead(user.UserID, request.IDs, ctx); err != nil {
+		log.Printf("handlers/inbox.go: MarkRead: cannot mark read: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	blocked, err := blockedIDs(ih.Hides, user.UserID, ctx)
+	if err != nil {
+		log.Printf("handlers/inbox.go: MarkRead: cannot get blocked users: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	unread, err := ih.Storage.CountUnread(user.UserID, blocked, ctx)
+	if err != nil {
+		log.Printf("handlers/inbox.go: MarkRead: cannot count unread: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(struct {
+		Unread int `json:"unread"`
+	}{unread})
+	w.Write(dataRaw)
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"time"
+	"unicode/utf8"
+
+	"reddit_clone/internals/events"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const maxMessageLen = 10000
+
+type MessageHandler struct {
+	Storage storage.MessageStorage
+	Users   storage.AuthStorage
+	Hides   storage.HideStorage
+	Bans    storage.BanStorage
+	Events  events.Broker
+}
+
+type NewMessage struct {
+	To   string `json:"to"`
+	Body string `json:"body"`
+}
+
+// users who blocked the sender do not get messages from them;
+// messages of shadow banned users are saved but never delivered
+func (mh *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	newMessage := &NewMessage{}
+	if err := json.NewDecoder(r.Body).Decode(newMessage); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	errors := misc.NewErrorBuilder()
+	if len(newMessage.To) == 0 {
+		errors.Add("body", "to", newMessage.To, "is required")
+	}
+	if len(newMessage.Body) == 0 {
+		errors.Add("body", "body", newMessage.Body, "is required")
+	} else if utf8.RuneCountInString(newMessage.Body) > maxMessageLen {
+		errStr := fmt.Sprintf("must be at most %d characters long", maxMessageLen)
+		errors.Add("body", "body", "", errStr)
+	}
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/messages.go: SendMessage: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	toID, ok := findUser(w, mh.Users, newMessage.To, "SendMessage")
+	if !ok {
+		return
+	}
+	if toID == user.UserID {
+		http.Error(w, misc.FormError("body", "to", newMessage.To, "cannot message yourself"), http.StatusUnprocessableEntity)
+		return
+	}
+	if blocked, err := mh.Hides.IsBlocked(user.UserID, toID, ctx); err != nil {
+		log.Printf("handlers/messages.go: SendMessage: cannot check block: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if blocked {
+		http.Error(w, misc.FormMessage("user blocked you"), http.StatusForbidden)
+		return
+	}
+	shadowBanned, err := mh.Bans.IsShadowBanned(user.UserID, ctx)
+	if err != nil {
+		log.Printf("handlers/messages.go: SendMessage: cannot get shadow banned: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+
+	message := &storage.DirectMessage{
+		From:    storage.Author{Username: user.Username, ID: user.UserID},
+		To:      storage.Author{Username: newMessage.To, ID: toID},
+		Body:    newMessage.Body,
+		Created: time.Now(),
+		Shadow:  shadowBanned,
+	}
+	if err := mh.Storage.SendMessage(message, ctx); err != nil {
+		log.Printf("handlers/messages.go: SendMessage: cannot send message: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if !message.Shadow {
+		publish(mh.Events, events.Event{Type: events.Message, Topic: events.InboxTopic(toID)})
+	}
+	dataRaw, _ := json.Marshal(message)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(dataRaw)
+}
+
+// messages and notifications of users blocked after they wrote are hidden,
+// new messages are not accepted at all
+func blockedIDs(hides storage.HideStorage, userID string, ctx context.Context) ([]string, error) {
+	blocked, err := hides.GetBlocked(userID, ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(blocked))
+	for _, author := range blocked {
+		ids = append(ids, author.ID)
+	}
+	return ids, nil
+}
+
+// lists inbox or sent messages depending on the last path element
+func (mh *MessageHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	errors := misc.NewErrorBuilder()
+	opts := parseListOptions(r, errors)
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/messages.go: GetMessages: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	var data []*storage.DirectMessage
+	switch path.Base(r.URL.Path) {
+	case "inbox":
+		var blocked []string
+		if blocked, err = blockedIDs(mh.Hides, user.UserID, ctx); err != nil {
+			log.Printf("handlers/messages.go: GetMessages: cannot get blocked users: %s\n", err)
+			misc.InternalError(w)
+			return
+		}
+		data, err = mh.Storage.GetInbox(user.UserID, blocked, opts, ctx)
+	case "sent":
+		data, err = mh.Storage.GetSent(user.UserID, opts, ctx)
+	}
+	if err != nil {
+		log.Printf("handlers/messages.go: GetMessages: cannot get messages: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+type Conversations struct {
+	Unread        int                     `json:"unread"`
+	Conversations []*storage.Conversation `json:"conversations"`
+}
+
+func (mh *MessageHandler) GetConversations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	errors := misc.NewErrorBuilder()
+	opts := parseListOptions(r, errors)
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/messages.go: GetConversations: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	blocked, err := blockedIDs(mh.Hides, user.UserID, ctx)
+	if err != nil {
+		log.Printf("handlers/messages.go: GetConversations: cannot get blocked users: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	conversations, err := mh.Storage.GetConversations(user.UserID, blocked, opts, ctx)
+	if err != nil {
+		log.Printf("handlers/messages.go: GetConversations: cannot get conversations: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	unread, err := mh.Storage.CountUnread(user.UserID, blocked, ctx)
+	if err != nil {
+		log.Printf("handlers/messages.go: GetConversations: cannot count unread: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(Conversations{Unread: unread, Conversations: conversations})
+	w.Write(dataRaw)
+}
+
+func (mh *MessageHandler) GetConversation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username, ok := mux.Vars(r)["username"]
+	if !ok {
+		log.Printf("handlers/messages.go: GetConversation: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	errors := misc.NewErrorBuilder()
+	opts := parseListOptions(r, errors)
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/messages.go: GetConversation: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	otherID, ok := findUser(w, mh.Users, username, "GetConversation")
+	if !ok {
+		return
+	}
+	data, err := mh.Storage.GetConversation(user.UserID, otherID, opts, ctx)
+	if err != nil {
+		log.Printf("handlers/messages.go: GetConversation: cannot get conversation: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+// read receipt: marks all messages from the user as read
+func (mh *MessageHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username, ok := mux.Vars(r)["username"]
+	if !ok {
+		log.Printf("handlers/messages.go: MarkRead: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/messages.go: MarkRead: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	otherID, ok := findUser(w, mh.Users, username, "MarkRead")
+	if !ok {
+		return
+	}
+	if err := mh.Storage.MarkRead(user.UserID, otherID, time.Now(), ctx); err != nil {
+		log.Printf("handlers/messages.go: MarkRead: cannot mark read: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	w.Write([]byte(misc.FormMessage("success")))
+}
@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"reddit_clone/internals/events"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+	"reddit_clone/internals/webhooks"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ModHandler struct {
+	Posts      storage.PostStorage
+	Categories storage.CategoryStorage
+	Reports    storage.ReportStorage
+	ModLog     storage.ModLogStorage
+	Users      storage.AuthStorage
+	Bans       storage.BanStorage
+	Automod    *AutoModerator
+	Events     events.Broker
+	Webhooks   *webhooks.Dispatcher
+}
+
+type PostMarks struct {
+	NSFW    bool `json:"nsfw"`
+	Spoiler bool `json:"spoiler"`
+}
+
+// gets post from route and checks that user moderates its category; writes error response if something is wrong
+func (mh *ModHandler) moderatedPost(w http.ResponseWriter, r *http.Request, funcName string) (*storage.Post, storage.User, bool) {
+	ctx := r.Context()
+	postID, ok := mux.Vars(r)["post_id"]
+	if !ok {
+		log.Printf("handlers/moderation.go: %s: bad routing: %s\n", funcName, r.URL.Path)
+		misc.InternalError(w)
+		return nil, storage.User{}, false
+	}
+	post, err := mh.Posts.FindPost(postID, ctx)
+	if err != nil {
+		log.Printf("handlers/moderation.go: %s: cannot find post: %s\n", funcName, err)
+		misc.InternalError(w)
+		return nil, storage.User{}, false
+	} else if post == nil {
+		http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
+		return nil, storage.User{}, false
+	}
+	user, ok := mh.checkModerator(w, r, post.Category, funcName)
+	if !ok {
+		return nil, storage.User{}, false
+	}
+	return post, user, true
+}
+
+// checks that user moderates category; writes error response if something is wrong
+func (mh *ModHandler) checkModerator(w http.ResponseWriter, r *http.Request, category, funcName string) (storage.User, bool) {
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/moderation.go: %s: cannot get user: %s\n", funcName, err)
+		misc.InternalError(w)
+		return storage.User{}, false
+	}
+	if isModerator, err := mh.Categories.IsModerator(category, user, r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: %s: cannot check moderator: %s\n", funcName, err)
+		misc.InternalError(w)
+		return storage.User{}, false
+	} else if !isModerator {
+		http.Error(w, misc.FormMessage("not a moderator"), http.StatusForbidden)
+		return storage.User{}, false
+	}
+	return user, true
+}
+
+// returns comment of the post from route or writes 404
+func findComment(w http.ResponseWriter, r *http.Request, post *storage.Post) (*storage.Comment, bool) {
+	commentID := mux.Vars(r)["comment_id"]
+	for i := range post.Comments {
+		if hex.EncodeToString(post.Comments[i].ID) == commentID {
+			return &post.Comments[i], true
+		}
+	}
+	http.Error(w, misc.FormMessage("comment not found"), http.StatusNotFound)
+	return nil, false
+}
+
+func newModLogEntry(category string, user storage.User, action string, target storage.ModLogTarget, reason string) *storage.ModLogEntry {
+	return &storage.ModLogEntry{
+		ID:        primitive.NewObjectID(),
+		Category:  category,
+		Moderator: storage.Author{Username: user.Username, ID: user.UserID},
+		Action:    action,
+		Target:    target,
+		Reason:    reason,
+		Created:   time.Now(),
+	}
+}
+
+// writes entry to mod log before the action is applied, so no action goes unlogged;
+// on failure writes error response and returns false
+func (mh *ModHandler) logAction(w http.ResponseWriter, r *http.Request, entry *storage.ModLogEntry, funcName string) bool {
+	if err := mh.ModLog.AddEntry(entry, r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: %s: cannot add mod log entry: %s\n", funcName, err)
+		misc.InternalError(w)
+		return false
+	}
+	return true
+}
+
+// takes entry back from mod log when its action failed, so the log lists only what happened
+func (mh *ModHandler) unlogAction(entry *storage.ModLogEntry, r *http.Request, funcName string) {
+	if err := mh.ModLog.RemoveEntry(entry.ID, r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: %s: cannot remove mod log entry: %s\n", funcName, err)
+	}
+}
+
+func postTarget(r *http.Request) storage.ModLogTarget {
+	vars := mux.Vars(r)
+	return storage.ModLogTarget{PostID: vars["post_id"], CommentID: vars["comment_id"]}
+}
+
+func (mh *ModHandler) writePost(w http.ResponseWriter, r *http.Request, funcName string) {
+	post, err := mh.Posts.FindPost(mux.Vars(r)["post_id"], r.Context())
+	if err != nil || post == nil {
+		log.Printf("handlers/moderation.go: %s: cannot find post: %v\n", funcName, err)
+		misc.InternalError(w)
+		return
+	}
+	publishPost(mh.Events, events.PostUpdated, post, mux.Vars(r)["post_id"], mux.Vars(r)["comment_id"])
+	post.RenderMarkdown()
+	dataRaw, _ := json.Marshal(post)
+	w.Write(dataRaw)
+}
+
+//-------------------------------------Remove and approve------------------------------//
+
+func (mh *ModHandler) RemovePost(w http.ResponseWriter, r *http.Request) {
+	post, user, ok := mh.moderatedPost(w, r, "RemovePost")
+	if !ok {
+		return
+	} else if post.Deleted != nil {
+		http.Error(w, misc.FormMessage("already deleted"), http.StatusConflict)
+		return
+	}
+	reason, err := decodeReason(r)
+	if err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	entry := newModLogEntry(post.Category, user, storage.ModActionRemovePost, postTarget(r), reason)
+	if !mh.logAction(w, r, entry, "RemovePost") {
+		return
+	}
+	if err := mh.Posts.DeletePost(mux.Vars(r)["post_id"], deletionBy(user, reason), r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: RemovePost: cannot remove post: %s\n", err)
+		mh.unlogAction(entry, r, "RemovePost")
+		misc.InternalError(w)
+		return
+	}
+	mh.dispatch(events.PostDeleted, mux.Vars(r)["post_id"], "", r.Context())
+	mh.writePost(w, r, "RemovePost")
+}
+
+func (mh *ModHandler) RemoveComment(w http.ResponseWriter, r *http.Request) {
+	post, user, ok := mh.moderatedPost(w, r, "RemoveComment")
+	if !ok {
+		return
+	}
+	comment, ok := findComment(w, r, post)
+	if !ok {
+		return
+	} else if comment.Deleted != nil {
+		http.Error(w, misc.FormMessage("already deleted"), http.StatusConflict)
+		return
+	}
+	reason, err := decodeReason(r)
+	if err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	entry := newModLogEntry(post.Category, user, storage.ModActionRemoveComment, postTarget(r), reason)
+	if !mh.logAction(w, r, entry, "RemoveComment") {
+		return
+	}
+	vars := mux.Vars(r)
+	if err := mh.Posts.DeleteComment(vars["post_id"], vars["comment_id"], deletionBy(user, reason), r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: RemoveComment: cannot remove comment: %s\n", err)
+		mh.unlogAction(entry, r, "RemoveComment")
+		misc.InternalError(w)
+		return
+	}
+	mh.dispatch(events.CommentDeleted, vars["post_id"], vars["comment_id"], r.Context())
+	mh.writePost(w, r, "RemoveComment")
+}
+
+// approving content removed by a moderator restores it, content deleted by its author stays deleted
+func (mh *ModHandler) ApprovePost(w http.ResponseWriter, r *http.Request) {
+	post, user, ok := mh.moderatedPost(w, r, "ApprovePost")
+	if !ok {
+		return
+	} else if post.Deleted != nil && post.Deleted.By.ID == post.Author.ID {
+		http.Error(w, misc.FormMessage("deleted by author"), http.StatusConflict)
+		return
+	}
+	action := storage.ModActionApprovePost
+	if post.Deleted != nil {
+		action = storage.ModActionRestorePost
+	}
+	entry := newModLogEntry(post.Category, user, action, postTarget(r), "")
+	if !mh.logAction(w, r, entry, "ApprovePost") {
+		return
+	}
+	if err := mh.Posts.ApprovePost(mux.Vars(r)["post_id"], approvalBy(user), r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: ApprovePost: cannot approve post: %s\n", err)
+		mh.unlogAction(entry, r, "ApprovePost")
+		misc.InternalError(w)
+		return
+	}
+	mh.writePost(w, r, "ApprovePost")
+}
+
+func (mh *ModHandler) ApproveComment(w http.ResponseWriter, r *http.Request) {
+	post, user, ok := mh.moderatedPost(w, r, "ApproveComment")
+	if !ok {
+		return
+	}
+	comment, ok := findComment(w, r, post)
+	if !ok {
+		return
+	} else if comment.Deleted != nil && comment.Deleted.By.ID == comment.Author.ID {
+		http.Error(w, misc.FormMessage("deleted by author"), http.StatusConflict)
+		return
+	}
+	action := storage.ModActionApproveComment
+	if comment.Deleted != nil {
+		action = storage.ModActionRestoreComment
+	}
+	entry := newModLogEntry(post.Category, user, action, postTarget(r), "")
+	if !mh.logAction(w, r, entry, "ApproveComment") {
+		return
+	}
+	vars := mux.Vars(r)
+	if err := mh.Posts.ApproveComment(vars["post_id"], vars["comment_id"], approvalBy(user), r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: ApproveComment: cannot approve comment: %s\n", err)
+		mh.unlogAction(entry, r, "ApproveComment")
+		misc.InternalError(w)
+		return
+	}
+	mh.writePost(w, r, "ApproveComment")
+}
+
+func approvalBy(user storage.User) storage.Approval {
+	return storage.Approval{
+		At: time.Now(),
+		By: storage.Author{Username: user.Username, ID: user.UserID},
+	}
+}
+
+//-------------------------------------Lock, pin and mark------------------------------//
+
+// handles lock and unlock depending on the last part of the path
+func (mh *ModHandler) LockPost(w http.ResponseWriter, r *http.Request) {
+	post, user, ok := mh.moderatedPost(w, r, "LockPost")
+	if !ok {
+		return
+	}
+	locked := path.Base(r.URL.Path) == "lock"
+	action := storage.ModActionUnlock
+	if locked {
+		action = storage.ModActionLock
+	}
+	entry := newModLogEntry(post.Category, user, action, postTarget(r), "")
+	if !mh.logAction(w, r, entry, "LockPost") {
+		return
+	}
+	if err := mh.Posts.LockPost(mux.Vars(r)["post_id"], locked, r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: LockPost: cannot lock post: %s\n", err)
+		mh.unlogAction(entry, r, "LockPost")
+		misc.InternalError(w)
+		return
+	}
+	mh.writePost(w, r, "LockPost")
+}
+
+// handles pin and unpin depending on the last part of the path
+func (mh *ModHandler) PinPost(w http.ResponseWriter, r *http.Request) {
+	post, user, ok := mh.moderatedPost(w, r, "PinPost")
+	if !ok {
+		return
+	}
+	pinned := path.Base(r.URL.Path) == "pin"
+	action := storage.ModActionUnpin
+	if pinned {
+		action = storage.ModActionPin
+	}
+	entry := newModLogEntry(post.Category, user, action, postTarget(r), "")
+	if !mh.logAction(w, r, entry, "PinPost") {
+		return
+	}
+	if err := mh.Posts.PinPost(mux.Vars(r)["post_id"], pinned, r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: PinPost: cannot pin post: %s\n", err)
+		mh.unlogAction(entry, r, "PinPost")
+		misc.InternalError(w)
+		return
+	}
+	mh.writePost(w, r, "PinPost")
+}
+
+func (mh *ModHandler) MarkPost(w http.ResponseWriter, r *http.Request) {
+	post, user, ok := mh.moderatedPost(w, r, "MarkPost")
+	if !ok {
+		return
+	}
+	marks := &PostMarks{}
+	if err := json.NewDecoder(r.Body).Decode(marks); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	reason := fmt.Sprintf("nsfw: %t, spoiler: %t", marks.NSFW, marks.Spoiler)
+	entry := newModLogEntry(post.Category, user, storage.ModActionMark, postTarget(r), reason)
+	if !mh.logAction(w, r, entry, "MarkPost") {
+		return
+	}
+	if err := mh.Posts.MarkPost(mux.Vars(r)["post_id"], marks.NSFW, marks.Spoiler, r.Context()); err != nil {
+		log.Printf("handlers/moderation.go: MarkPost: cannot mark post: %s\n", err)
+		mh.unlogAction(entry, r, "MarkPost")
+		misc.InternalError(w)
+		return
+	}
+	mh.writePost(w, r, "MarkPost")
+}
+
+//-------------------------------------Bans--------------------------------------------//
+
+func (mh *ModHandler) BanUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	category, categoryOK := mux.Vars(r)["category"]
+	username, usernameOK := mux.Vars(r)["username"]
+	if !categoryOK || !usernameOK {
+		log.Printf("handlers/moderation.go: BanUser: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, ok := mh.checkModerator(w, r, category, "BanUser")
+	if !ok {
+		return
+	}
+	userID, ok := findUser(w, mh.Users, username, "BanUser")
+	if !ok {
+		return
+	}
+	ban, ok := decodeBan(w, r, user)
+	if !ok {
+		return
+	}
+	target := storage.ModLogTarget{Username: username}
+	entry := newModLogEntry(category, user, storage.ModActionBan, target, ban.Reason)
+	if !mh.logAction(w, r, entry, "BanUser") {
+		return
+	}
+	if err := mh.Bans.BanFromCategory(category, userID, ban, ctx); err != nil {
+		log.Printf("handlers/moderation.go: BanUser: cannot ban: %s\n", err)
+		mh.unlogAction(entry, r, "BanUser")
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(ban)
+	w.Write(dataRaw)
+}
+
+func (mh *ModHandler) UnbanUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	category, categoryOK := mux.Vars(r)["category"]
+	username, usernameOK := mux.Vars(r)["username"]
+	if !categoryOK || !usernameOK {
+		log.Printf("handlers/moderation.go: UnbanUser: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, ok := mh.checkModerator(w, r, category, "UnbanUser")
+	if !ok {
+		return
+	}
+	userID, ok := findUser(w, mh.Users, username, "UnbanUser")
+	if !ok {
+		return
+	}
+	target := storage.ModLogTarget{Username: username}
+	entry := newModLogEntry(category, user, storage.ModActionUnban, target, "")
+	if !mh.logAction(w, r, entry, "UnbanUser") {
+		return
+	}
+	if err := mh.Bans.UnbanFromCategory(category, userID, ctx); err != nil {
+		log.Printf("handlers/moderation.go: UnbanUser: cannot unban: %s\n", err)
+		mh.unlogAction(entry, r, "UnbanUser")
+		misc.InternalError(w)
+		return
+	}
+	w.Write([]byte(misc.FormMessage("success")))
+}
+
+//-------------------------------------Mod log-----------------------------------------//
+
+// reads moderator, action, since, until (RFC3339), limit and offset from query
+func parseModLogFilter(r *http.Request, category string, errors *misc.ErrorBuilder) storage.ModLogFilter {
+	query := r.URL.Query()
+	opts := parseListOptions(r, errors)
+	filter := storage.ModLogFilter{
+		Category:  category,
+		Moderator: query.Get("moderator"),
+		Action:    query.Get("action"),
+		Limit:     opts.Limit,
+		Offset:    opts.Offset,
+	}
+	if sinceStr := query.Get("since"); sinceStr != "" {
+		if since, err := time.Parse(time.RFC3339, sinceStr); err != nil {
+			errors.Add("query", "since", sinceStr, "must be RFC3339 time")
+		} else {
+			filter.Since = since
+		}
+	}
+	if untilStr := query.Get("until"); untilStr != "" {
+		if until, err := time.Parse(time.RFC3339, untilStr); err != nil {
+			errors.Add("query", "until", untilStr, "must be RFC3339 time")
+		} else {
+			filter.Until = until
+		}
+	}
+	return filter
+}
+
+func (mh *ModHandler) GetModLog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	category, ok := mux.Vars(r)["category"]
+	if !ok {
+		log.Printf("handlers/moderation.go: GetModLog: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	if _, ok := mh.checkModerator(w, r, category, "GetModLog"); !ok {
+		return
+	}
+	errors := misc.NewErrorBuilder()
+	filter := parseModLogFilter(r, category, errors)
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	data, err := mh.ModLog.GetEntries(filter, ctx)
+	if err != nil {
+		log.Printf("handlers/moderation.go: GetModLog: cannot get mod log: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
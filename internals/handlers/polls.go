@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"reddit_clone/internals/events"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	minPollOptions   = 2
+	maxPollOptions   = 10
+	maxPollOptionLen = 100
+)
+
+type PollVote struct {
+	Options []int `json:"options"`
+}
+
+func validatePoll(poll *storage.NewPoll, errors *misc.ErrorBuilder) {
+	if poll == nil {
+		errors.Add("body", "poll", "", "cannot be blank")
+		return
+	}
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		errStr := fmt.Sprintf("must have from %d to %d options", minPollOptions, maxPollOptions)
+		errors.Add("body", "poll.options", strconv.Itoa(len(poll.Options)), errStr)
+	}
+	seen := map[string]struct{}{}
+	for _, option := range poll.Options {
+		if len(option) == 0 {
+			errors.Add("body", "poll.options", "", "cannot be blank")
+		} else if misc.IsBorderSpace(option) {
+			errors.Add("body", "poll.options", option, "cannot start or end with whitespace")
+		} else if len(option) > maxPollOptionLen {
+			errStr := fmt.Sprintf("must be at most %d characters long", maxPollOptionLen)
+			errors.Add("body", "poll.options", option, errStr)
+		} else if _, ok := seen[strings.ToLower(option)]; ok {
+			errors.Add("body", "poll.options", option, "must be unique")
+		}
+		seen[strings.ToLower(option)] = struct{}{}
+	}
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(time.Now()) {
+		errors.Add("body", "poll.closes_at", poll.ClosesAt.Format(time.RFC3339), "must be in the future")
+	}
+}
+
+// one ballot per user, it cannot be changed; results show up in the returned post
+func (ph *PostHandler) VotePoll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, ok := mux.Vars(r)["post_id"]
+	if !ok {
+		log.Printf("handlers/polls.go: VotePoll: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	post, err := ph.Storage.FindPost(postID, ctx)
+	if err != nil {
+		log.Printf("handlers/polls.go: VotePoll: cannot find post: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if post == nil || post.Deleted != nil {
+		http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
+		return
+	} else if post.Poll == nil {
+		http.Error(w, misc.FormError("path", "post_id", postID, "is not a poll"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	vote := &PollVote{}
+	if err := json.NewDecoder(r.Body).Decode(vote); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	errors := misc.NewErrorBuilder()
+	if len(vote.Options) == 0 {
+		errors.Add("body", "options", "", "must choose an option")
+	} else if len(vote.Options) > 1 && !post.Poll.Multiple {
+		errors.Add("body", "options", strconv.Itoa(len(vote.Options)), "only one option can be chosen")
+	}
+	chosen := map[int]struct{}{}
+	for _, option := range vote.Options {
+		if option < 0 || option >= len(post.Poll.Options) {
+			errors.Add("body", "options", strconv.Itoa(option), "no such option")
+		} else if _, ok := chosen[option]; ok {
+			errors.Add("body", "options", strconv.Itoa(option), "cannot be chosen twice")
+		}
+		chosen[option] = struct{}{}
+	}
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/polls.go: VotePoll: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if !checkCategoryBan(w, r, ph.Bans, post.Category, user, "path", "VotePoll") {
+		return
+	}
+	if post.Poll.ClosesAt != nil && !time.Now().Before(*post.Poll.ClosesAt) {
+		http.Error(w, misc.FormMessage("poll is closed"), http.StatusForbidden)
+		return
+	}
+	if voted, err := ph.Storage.VotePoll(postID, vote.Options, user, ctx); err != nil {
+		log.Printf("handlers/polls.go: VotePoll: cannot vote: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !voted {
+		http.Error(w, misc.FormMessage("already voted"), http.StatusConflict)
+		return
+	}
+	publishPost(ph.Events, events.PostVoted, post, postID, "")
+
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/polls.go: VotePoll: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPost(postID, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/polls.go: VotePoll: cannot get post: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
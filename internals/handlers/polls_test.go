@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+)
+
+func TestValidatePoll(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	options := func(n int) []string {
+		texts := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			texts = append(texts, strings.Repeat("o", i+1))
+		}
+		return texts
+	}
+	tests := []struct {
+		name string
+		poll *storage.NewPoll
+		want string // part of the error, empty when poll is valid
+	}{
+		{"valid", &storage.NewPoll{Options: []string{"yes", "no"}}, ""},
+		{"most options", &storage.NewPoll{Options: options(maxPollOptions), Multiple: true}, ""},
+		{"closes in future", &storage.NewPoll{Options: []string{"yes", "no"}, ClosesAt: &future}, ""},
+		{"longest option", &storage.NewPoll{Options: []string{strings.Repeat("a", maxPollOptionLen), "b"}}, ""},
+
+		{"no poll", nil, "cannot be blank"},
+		{"one option", &storage.NewPoll{Options: []string{"yes"}}, "must have from 2 to 10 options"},
+		{"too many options", &storage.NewPoll{Options: options(maxPollOptions + 1)}, "must have from 2 to 10 options"},
+		{"blank option", &storage.NewPoll{Options: []string{"yes", ""}}, "cannot be blank"},
+		{"border space", &storage.NewPoll{Options: []string{"yes", "no "}}, "cannot start or end with whitespace"},
+		{"long option", &storage.NewPoll{Options: []string{strings.Repeat("a", maxPollOptionLen+1), "b"}}, "must be at most 100 characters long"},
+		{"duplicate options", &storage.NewPoll{Options: []string{"Yes", "yes"}}, "must be unique"},
+		{"closed already", &storage.NewPoll{Options: []string{"yes", "no"}, ClosesAt: &past}, "must be in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errors := misc.NewErrorBuilder()
+			validatePoll(tt.poll, errors)
+			if tt.want == "" && !errors.Empty() {
+				t.Errorf("validatePoll() = %s, want no errors", errors.Error())
+			} else if tt.want != "" && !strings.Contains(errors.Error(), tt.want) {
+				t.Errorf("validatePoll() = %s, want %q", errors.Error(), tt.want)
+			}
+		})
+	}
+}
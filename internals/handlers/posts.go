@@ -1,20 +1,161 @@
 package handlers
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"path"
+	"strconv"
+	"time"
+	"unicode/utf8"
 
+	"reddit_clone/internals/events"
+	"reddit_clone/internals/images"
 	"reddit_clone/internals/misc"
+	"reddit_clone/internals/previews"
 	"reddit_clone/internals/storage"
+	"reddit_clone/internals/webhooks"
 
 	"github.com/gorilla/mux"
 )
 
+// limits of markdown texts, which are rendered when written
+const (
+	maxPostTextLen = 40000
+	maxCommentLen  = 10000
+)
+
 type PostHandler struct {
-	Storage storage.PostStorage
+	Storage       storage.PostStorage
+	Categories    storage.CategoryStorage
+	Bans          storage.BanStorage
+	Saved         storage.SavedStorage
+	Hides         storage.HideStorage
+	Notifications storage.NotificationStorage
+	Users         storage.AuthStorage
+	Events        events.Broker
+	Webhooks      *webhooks.Dispatcher
+	Previews      *previews.Fetcher // link previews are not fetched if nil
+	Blobs         storage.BlobStore // images of image posts
+	Images        *images.Processor
+	Automod       *AutoModerator
+	Domains       misc.DomainPolicy // site-wide, every category has its own too
+	RestoreWindow time.Duration     // how long after deletion an author can restore their post or comment
+	RepostWindow  time.Duration     // how long the same link cannot be posted in the same category without force
+}
+
+type Reposts struct {
+	Message string   `json:"message"`
+	Posts   []string `json:"posts"`
+}
+
+const (
+	defaultPageLimit = 25
+	maxPageLimit     = 100
+)
+
+// reads sort, limit and offset from query
+func parseListOptions(r *http.Request, errors *misc.ErrorBuilder) storage.ListOptions {
+	query := r.URL.Query()
+	opts := storage.ListOptions{Sort: storage.SortNew, Limit: defaultPageLimit}
+
+	if sort := query.Get("sort"); sort == storage.SortNew || sort == storage.SortTop {
+		opts.Sort = sort
+	} else if sort != "" {
+		errors.Add("query", "sort", sort, "must be new or top")
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if limit, err := strconv.Atoi(limitStr); err != nil || limit < 1 || limit > maxPageLimit {
+			errors.Add("query", "limit", limitStr, fmt.Sprintf("must be a number from 1 to %d", maxPageLimit))
+		} else {
+			opts.Limit = limit
+		}
+	}
+
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		if offset, err := strconv.Atoi(offsetStr); err != nil || offset < 0 {
+			errors.Add("query", "offset", offsetStr, "must be a non-negative number")
+		} else {
+			opts.Offset = offset
+		}
+	}
+	return opts
+}
+
+type Reason struct {
+	Reason string `json:"reason"`
+}
+
+// builds viewer from request, request may be anonymous;
+// content of shadow banned users is flagged and hidden by storage from everyone except themselves,
+// blocked users and hidden posts only from the one who blocked or hid them;
+// categories the user moderates are listed so their deleted comments keep details
+func (ph *PostHandler) viewer(r *http.Request) (storage.Viewer, error) {
+	ctx := r.Context()
+	viewer := storage.Viewer{}
+	if user, err := GetUser(r); err == nil {
+		viewer.UserID = user.UserID
+	}
+	if viewer.UserID == "" {
+		return viewer, nil
+	}
+	blocked, err := ph.Hides.GetBlocked(viewer.UserID, ctx)
+	if err != nil {
+		return storage.Viewer{}, err
+	}
+	for _, author := range blocked {
+		viewer.HiddenAuthors = append(viewer.HiddenAuthors, author.ID)
+	}
+	if viewer.HiddenPosts, err = ph.Hides.GetHiddenPosts(viewer.UserID, ctx); err != nil {
+		return storage.Viewer{}, err
+	}
+	if viewer.Moderates, err = ph.Categories.GetModerated(storage.User{UserID: viewer.UserID}, ctx); err != nil {
+		return storage.Viewer{}, err
+	}
+	return viewer, nil
+}
+
+// reason is optional, so empty body is fine
+func decodeReason(r *http.Request) (string, error) {
+	reason := &Reason{}
+	if err := json.NewDecoder(r.Body).Decode(reason); err != nil && err != io.EOF {
+		return "", err
+	}
+	return reason.Reason, nil
+}
+
+func deletionBy(user storage.User, reason string) storage.Deletion {
+	return storage.Deletion{
+		At:     time.Now(),
+		By:     storage.Author{Username: user.Username, ID: user.UserID},
+		Reason: reason,
+	}
+}
+
+// writes error response for not successful restore status, returns true if status is ok
+func checkRestoreStatus(w http.ResponseWriter, respCode int, funcName string) bool {
+	switch respCode {
+	case storage.StatusRestoreOK:
+		return true
+	case storage.StatusPostNotExist:
+		http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
+	case storage.StatusCommentNotExist:
+		http.Error(w, misc.FormMessage("comment not found"), http.StatusNotFound)
+	case storage.StatusNotDeleted:
+		http.Error(w, misc.FormMessage("not deleted"), http.StatusConflict)
+	case storage.StatusRestoreForbidden:
+		http.Error(w, misc.FormMessage("unauthorized"), http.StatusUnauthorized)
+	case storage.StatusRestoreExpired:
+		http.Error(w, misc.FormMessage("restore window expired"), http.StatusForbidden)
+	default:
+		log.Printf("handlers/posts.go: %s: unknown respCode: %d\n", funcName, respCode)
+		misc.InternalError(w)
+	}
+	return false
 }
 
 //-------------------------------------Get Post & Posts--------------------------------//
@@ -28,15 +169,21 @@ func (ph *PostHandler) GetPost(w http.ResponseWriter, r *http.Request) {
 		misc.InternalError(w)
 		return
 	}
-	if exist, err := ph.Storage.CheckPostExist(postID, ctx); err != nil {
-		log.Printf("handlers/posts.go: GetPost: cannot check post existance: %s\n", err)
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: GetPost: cannot get viewer: %s\n", err)
 		misc.InternalError(w)
 		return
-	} else if !exist {
+	}
+	if post, err := ph.Storage.FindPost(postID, ctx); err != nil {
+		log.Printf("handlers/posts.go: GetPost: cannot find post: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if post == nil || post.Deleted != nil || viewer.Hides(post.Author, post.Shadow) {
 		http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
 		return
 	}
-	data, err := ph.Storage.GetPost(postID, ctx)
+	data, err := ph.Storage.GetPost(postID, viewer, ctx)
 	if err != nil {
 		log.Printf("handlers/posts.go: GetPost: cannot get post: %s\n", err)
 		misc.InternalError(w)
@@ -48,7 +195,13 @@ func (ph *PostHandler) GetPost(w http.ResponseWriter, r *http.Request) {
 
 func (ph *PostHandler) GetPosts(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	data, err := ph.Storage.GetPosts(ctx)
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: GetPosts: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPosts(viewer, ctx)
 	if err != nil {
 		log.Printf("handlers/posts.go: GetPosts: cannot get posts: %s\n", err)
 		misc.InternalError(w)
@@ -67,7 +220,13 @@ func (ph *PostHandler) GetPostsByCategory(w http.ResponseWriter, r *http.Request
 		http.Error(w, misc.FormMessage("internal error: bad routing"), http.StatusInternalServerError)
 		return
 	}
-	data, err := ph.Storage.GetPostsByCategory(category, ctx)
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: GetPostsByCategory: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPostsByCategory(category, viewer, ctx)
 	if err != nil {
 		log.Printf("handlers/posts.go: GetPostsByCategory: cannot get posts: %s\n", err)
 		misc.InternalError(w)
@@ -93,7 +252,13 @@ func (ph *PostHandler) GetPostByUsername(w http.ResponseWriter, r *http.Request)
 		http.Error(w, misc.FormMessage("user not exist"), http.StatusNotFound)
 		return
 	}
-	data, err := ph.Storage.GetPostsByUsername(username, ctx)
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: GetPostsByUsername: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPostsByUsername(username, viewer, ctx)
 	if err != nil {
 		log.Printf("handlers/posts.go: GetPostsByUsername: cannot get posts by user: %s\n", err)
 		misc.InternalError(w)
@@ -103,26 +268,112 @@ func (ph *PostHandler) GetPostByUsername(w http.ResponseWriter, r *http.Request)
 	w.Write(dataRaw)
 }
 
+func (ph *PostHandler) GetCommentsByUsername(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username, ok := mux.Vars(r)["username"]
+	if !ok {
+		log.Printf("handlers/posts.go: GetCommentsByUsername: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	errors := misc.NewErrorBuilder()
+	opts := parseListOptions(r, errors)
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if exist, err := ph.Storage.CheckUserExist(username, ctx); err != nil {
+		log.Printf("handlers/posts.go: GetCommentsByUsername: cannot check user existance: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !exist {
+		http.Error(w, misc.FormMessage("user not exist"), http.StatusNotFound)
+		return
+	}
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: GetCommentsByUsername: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetCommentsByUsername(username, opts, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: GetCommentsByUsername: cannot get comments by user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+// posts from subscribed categories; anonymous users and users without subscriptions get all posts
+func (ph *PostHandler) GetFeed(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	errors := misc.NewErrorBuilder()
+	opts := parseListOptions(r, errors)
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var categories []string
+	if user, err := GetUser(r); err == nil {
+		subscriptions, err := ph.Categories.GetSubscriptions(user, ctx)
+		if err != nil {
+			log.Printf("handlers/posts.go: GetFeed: cannot get subscriptions: %s\n", err)
+			misc.InternalError(w)
+			return
+		} else if len(subscriptions) > 0 {
+			categories = subscriptions
+		}
+	}
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: GetFeed: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetFeed(categories, opts, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: GetFeed: cannot get feed: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
 //-------------------------------------Create and delete post--------------------------//
 
 func (ph *PostHandler) MakePost(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	minPostLen := 4
 	validTypes := map[string]struct{}{
-		"text": {},
-		"link": {},
+		"text":  {},
+		"link":  {},
+		"image": {},
+		"poll":  {},
 	}
 
 	post := &storage.NewPost{}
-	if err := json.NewDecoder(r.Body).Decode(post); err != nil {
+	var upload []byte
+	if isMultipart(r) {
+		var ok bool
+		if upload, ok = readImagePost(w, r, post); !ok {
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(post); err != nil {
 		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
 		return
 	}
 	if post.Type == "link" {
 		post.Text = ""
-	} else if post.Type == "text" {
+	} else if post.Type == "text" || post.Type == "image" || post.Type == "poll" {
 		post.URL = ""
 	}
+	if post.Type != "poll" {
+		post.Poll = nil
+	}
 
 	errors := misc.NewErrorBuilder()
 
@@ -134,24 +385,51 @@ func (ph *PostHandler) MakePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// url or text depending on type value
-	isValidURL := func(str string) bool {
-		u, err := url.Parse(str)
-		return err == nil && u.Scheme != "" && u.Host != ""
-	}
-	if post.Type == "link" && !isValidURL(post.URL) {
-		errors.Add("body", "url", post.URL, "is invalid")
-	} else if post.Type != "link" && len(post.Text) < minPostLen {
+	if post.Type == "link" {
+		if canonicalURL, err := misc.NormalizeURL(post.URL); err != nil {
+			errors.Add("body", "url", post.URL, err.Error())
+		} else if !ph.Domains.Allows(canonicalURL) {
+			errors.Add("body", "url", post.URL, "domain is not allowed")
+		} else {
+			post.CanonicalURL = canonicalURL
+		}
+	} else if post.Type == "image" {
+		// text of image post is an optional caption
+		if upload == nil {
+			errors.Add("body", "image", "", "must be uploaded as multipart form")
+		}
+	} else if post.Type == "poll" {
+		// text of poll is an optional description
+		validatePoll(post.Poll, errors)
+	} else if len(post.Text) < minPostLen {
 		errors.Add("body", "text", post.Text, "must be at least 4 characters long")
 	}
+	if utf8.RuneCountInString(post.Text) > maxPostTextLen {
+		errors.Add("body", "text", "", fmt.Sprintf("must be at most %d characters long", maxPostTextLen))
+	}
 
 	// category
 	if len(post.Category) == 0 {
 		errors.Add("body", "category", "", "cannot be blank")
+	} else if exist, err := ph.Categories.CheckCategoryExist(post.Category, ctx); err != nil {
+		log.Printf("handlers/posts.go: MakePost: cannot check category existance: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !exist {
+		errors.Add("body", "category", post.Category, "does not exist")
+	} else if post.CanonicalURL != "" {
+		if category, err := ph.Categories.GetCategory(post.Category, ctx); err != nil {
+			log.Printf("handlers/posts.go: MakePost: cannot get category: %s\n", err)
+			misc.InternalError(w)
+			return
+		} else if !category.Domains.Allows(post.CanonicalURL) {
+			errors.Add("body", "url", post.URL, "domain is not allowed in this category")
+		}
 	}
 
 	// type
 	if _, ok := validTypes[post.Type]; !ok {
-		errors.Add("body", "type", post.Type, "must be a link or text post")
+		errors.Add("body", "type", post.Type, "must be a link, text, image or poll post")
 	}
 
 	if !errors.Empty() {
@@ -165,19 +443,76 @@ func (ph *PostHandler) MakePost(w http.ResponseWriter, r *http.Request) {
 		misc.InternalError(w)
 		return
 	}
+	if !checkCategoryBan(w, r, ph.Bans, post.Category, user, "body", "MakePost") {
+		return
+	}
+	if post.CanonicalURL != "" && !post.Force {
+		// only posts the poster can see count, or hidden ones would block the link with ids which 404
+		viewer, err := ph.viewer(r)
+		if err != nil {
+			log.Printf("handlers/posts.go: MakePost: cannot get viewer: %s\n", err)
+			misc.InternalError(w)
+			return
+		}
+		since := time.Now().Add(-ph.RepostWindow)
+		if reposts, err := ph.Storage.FindReposts(post.Category, post.CanonicalURL, since, viewer, ctx); err != nil {
+			log.Printf("handlers/posts.go: MakePost: cannot find reposts: %s\n", err)
+			misc.InternalError(w)
+			return
+		} else if len(reposts) > 0 {
+			dataRaw, _ := json.Marshal(Reposts{Message: "already posted, use force to post anyway", Posts: reposts})
+			http.Error(w, string(dataRaw), http.StatusConflict)
+			return
+		}
+	}
+	if post.Type == "image" {
+		image, err := ph.Images.Process(upload, ctx)
+		if err == images.ErrUnsupported || err == images.ErrTooLarge {
+			errors.Add("body", "image", "", err.Error())
+			http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			log.Printf("handlers/posts.go: MakePost: cannot process image: %s\n", err)
+			misc.InternalError(w)
+			return
+		}
+		if post.Image, err = ph.storeImage(image, ctx); err != nil {
+			log.Printf("handlers/posts.go: MakePost: cannot store image: %s\n", err)
+			misc.InternalError(w)
+			return
+		}
+	}
 	postID, err := ph.Storage.MakePost(post, user, ctx)
 	if err != nil {
 		log.Printf("handlers/posts.go: MakePost: cannot make post: %s\n", err)
+		if post.Image != nil {
+			ph.deleteImage(post.Image, ctx)
+		}
 		misc.InternalError(w)
 		return
 	}
+	// post is already made, so failures below do not fail the request, a retry would make a duplicate
 	if err := ph.Storage.Rate(postID, 1, user, ctx); err != nil {
 		log.Printf("handlers/posts.go: MakePost: cannot upvote new post: %s\n", err)
+	}
+	if newPost, err := ph.Storage.FindPost(postID, ctx); err != nil || newPost == nil {
+		log.Printf("handlers/posts.go: MakePost: cannot find new post: %v\n", err)
+	} else if err := ph.Automod.CheckPost(postID, newPost, ctx); err != nil {
+		log.Printf("handlers/posts.go: MakePost: cannot check automod rules: %s\n", err)
+	}
+	ph.publishCreated(events.PostCreated, postID, "", ctx)
+	ph.dispatch(events.PostCreated, postID, "", ctx)
+	if post.CanonicalURL != "" {
+		ph.fetchPreview(postID, post.CanonicalURL)
+	}
+
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: MakePost: cannot get viewer: %s\n", err)
 		misc.InternalError(w)
 		return
 	}
-
-	data, err := ph.Storage.GetPost(postID, ctx)
+	data, err := ph.Storage.GetPost(postID, viewer, ctx)
 	if err != nil {
 		log.Printf("handlers/posts.go: MakePost: cannot get post: %s\n", err)
 		misc.InternalError(w)
@@ -204,7 +539,8 @@ func (ph *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user, err := GetUser(r); err != nil {
+	user, err := GetUser(r)
+	if err != nil {
 		log.Printf("handlers/posts.go: DeletePost: cannot get user: %s\n", err)
 		misc.InternalError(w)
 		return
@@ -216,19 +552,68 @@ func (ph *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, misc.FormMessage("unauthorized"), http.StatusUnauthorized)
 		return
 	}
+	reason, err := decodeReason(r)
+	if err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
 
-	if err := ph.Storage.DeletePost(postID, ctx); err != nil {
+	if err := ph.Storage.DeletePost(postID, deletionBy(user, reason), ctx); err != nil {
 		log.Printf("handlers/posts.go: DeletePost: cannot delete post: %s\n", err)
 		misc.InternalError(w)
 		return
 	}
+	ph.publish(events.PostDeleted, postID, "", ctx)
+	ph.dispatch(events.PostDeleted, postID, "", ctx)
 	w.Write([]byte(misc.FormMessage("success")))
 }
 
+func (ph *PostHandler) RestorePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, ok := mux.Vars(r)["post_id"]
+	if !ok {
+		log.Printf("handlers/posts.go: RestorePost: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: RestorePost: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	respCode, err := ph.Storage.RestorePost(postID, user, ph.RestoreWindow, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: RestorePost: cannot restore post: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !checkRestoreStatus(w, respCode, "RestorePost") {
+		return
+	}
+	ph.publish(events.PostRestored, postID, "", ctx)
+
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: RestorePost: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPost(postID, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: RestorePost: cannot get post: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
 //-----------------------------Create and delete comments------------------------------//
 
+// empty ParentID means reply to the post itself
 type Comment struct {
-	Comment string `json:"comment"`
+	Comment  string `json:"comment"`
+	ParentID string `json:"parent_id"`
 }
 
 func (ph *PostHandler) MakeComment(w http.ResponseWriter, r *http.Request) {
@@ -239,13 +624,17 @@ func (ph *PostHandler) MakeComment(w http.ResponseWriter, r *http.Request) {
 		misc.InternalError(w)
 		return
 	}
-	if exist, err := ph.Storage.CheckPostExist(postID, ctx); err != nil {
-		log.Printf("handlers/posts.go: MakeComment: cannot check post existance: %s\n", err)
+	post, err := ph.Storage.FindPost(postID, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: MakeComment: cannot find post: %s\n", err)
 		misc.InternalError(w)
 		return
-	} else if !exist {
+	} else if post == nil || post.Deleted != nil {
 		http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
 		return
+	} else if post.Locked {
+		http.Error(w, misc.FormMessage("post is locked"), http.StatusForbidden)
+		return
 	}
 	comment := &Comment{}
 	if err := json.NewDecoder(r.Body).Decode(comment); err != nil {
@@ -254,6 +643,10 @@ func (ph *PostHandler) MakeComment(w http.ResponseWriter, r *http.Request) {
 	} else if len(comment.Comment) == 0 {
 		http.Error(w, misc.FormError("body", "comment", "", "is required"), http.StatusUnprocessableEntity)
 		return
+	} else if utf8.RuneCountInString(comment.Comment) > maxCommentLen {
+		errStr := fmt.Sprintf("must be at most %d characters long", maxCommentLen)
+		http.Error(w, misc.FormError("body", "comment", "", errStr), http.StatusUnprocessableEntity)
+		return
 	}
 	user, err := GetUser(r)
 	if err != nil {
@@ -261,12 +654,67 @@ func (ph *PostHandler) MakeComment(w http.ResponseWriter, r *http.Request) {
 		misc.InternalError(w)
 		return
 	}
-	if err := ph.Storage.MakeComment(postID, comment.Comment, user, ctx); err != nil {
+	if !checkCategoryBan(w, r, ph.Bans, post.Category, user, "path", "MakeComment") {
+		return
+	}
+	var parent *storage.Comment
+	if comment.ParentID != "" {
+		for i := range post.Comments {
+			if hex.EncodeToString(post.Comments[i].ID) == comment.ParentID && post.Comments[i].Deleted == nil {
+				parent = &post.Comments[i]
+				break
+			}
+		}
+		if parent == nil {
+			http.Error(w, misc.FormError("body", "parent_id", comment.ParentID, "comment not found"), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if blocked, err := ph.Hides.IsBlocked(user.UserID, post.Author.ID, ctx); err != nil {
+		log.Printf("handlers/posts.go: MakeComment: cannot check block: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if blocked {
+		http.Error(w, misc.FormMessage("author of the post blocked you"), http.StatusForbidden)
+		return
+	}
+	if parent != nil {
+		if blocked, err := ph.Hides.IsBlocked(user.UserID, parent.Author.ID, ctx); err != nil {
+			log.Printf("handlers/posts.go: MakeComment: cannot check block: %s\n", err)
+			misc.InternalError(w)
+			return
+		} else if blocked {
+			http.Error(w, misc.FormMessage("author of the comment blocked you"), http.StatusForbidden)
+			return
+		}
+	}
+	commentID, err := ph.Storage.MakeComment(postID, comment.ParentID, comment.Comment, user, ctx)
+	if err != nil {
 		log.Printf("handlers/posts.go: MakeComment: cannot make comment: %s\n", err)
 		misc.InternalError(w)
 		return
 	}
-	data, err := ph.Storage.GetPost(postID, ctx)
+	newComment := &storage.Comment{
+		Author:   storage.Author{Username: user.Username, ID: user.UserID},
+		Body:     comment.Comment,
+		ParentID: comment.ParentID,
+	}
+	// comment is already made, so failed automod checks and notifications do not fail the request
+	if err := ph.Automod.CheckComment(postID, commentID, post, newComment, ctx); err != nil {
+		log.Printf("handlers/posts.go: MakeComment: cannot check automod rules: %s\n", err)
+	}
+	if err := ph.notifyComment(postID, post, parent, commentID, comment.Comment, user, ctx); err != nil {
+		log.Printf("handlers/posts.go: MakeComment: cannot notify: %s\n", err)
+	}
+	ph.publishCreated(events.CommentCreated, postID, commentID, ctx)
+	ph.dispatch(events.CommentCreated, postID, commentID, ctx)
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: MakeComment: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPost(postID, viewer, ctx)
 	if err != nil {
 		log.Printf("handlers/posts.go: MakeComment: cannot get post: %s\n", err)
 		misc.InternalError(w)
@@ -317,13 +765,27 @@ func (ph *PostHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := ph.Storage.DeleteComment(postID, commentID, ctx); err != nil {
+	reason, err := decodeReason(r)
+	if err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+
+	if err := ph.Storage.DeleteComment(postID, commentID, deletionBy(user, reason), ctx); err != nil {
 		log.Printf("handlers/posts.go: DeleteComment: cannot delete comment: %s\n", err)
 		misc.InternalError(w)
 		return
 	}
+	ph.publish(events.CommentDeleted, postID, commentID, ctx)
+	ph.dispatch(events.CommentDeleted, postID, commentID, ctx)
 
-	data, err := ph.Storage.GetPost(postID, ctx)
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: DeleteComment: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPost(postID, viewer, ctx)
 	if err != nil {
 		log.Printf("handlers/posts.go: DeleteComment: cannot get post: %s\n", err)
 		misc.InternalError(w)
@@ -334,6 +796,47 @@ func (ph *PostHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 	w.Write(dataRaw)
 }
 
+func (ph *PostHandler) RestoreComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID, postOK := mux.Vars(r)["post_id"]
+	commentID, commentOK := mux.Vars(r)["comment_id"]
+	if !postOK || !commentOK {
+		log.Printf("handlers/posts.go: RestoreComment: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: RestoreComment: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	respCode, err := ph.Storage.RestoreComment(postID, commentID, user, ph.RestoreWindow, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: RestoreComment: cannot restore comment: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !checkRestoreStatus(w, respCode, "RestoreComment") {
+		return
+	}
+	ph.publish(events.CommentRestored, postID, commentID, ctx)
+
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: RestoreComment: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPost(postID, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: RestoreComment: cannot get post: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
 //---------------------------------------Rating----------------------------------------//
 
 func (ph *PostHandler) Vote(w http.ResponseWriter, r *http.Request) {
@@ -343,11 +846,13 @@ func (ph *PostHandler) Vote(w http.ResponseWriter, r *http.Request) {
 		log.Printf("handlers/posts.go: Vote: bad routing: %s\n", r.URL.Path)
 		misc.InternalError(w)
 		return
-	} else if exist, err := ph.Storage.CheckPostExist(postID, ctx); err != nil {
-		log.Printf("handlers/posts.go: Vote: cannot check post existance: %s\n", err)
+	}
+	post, err := ph.Storage.FindPost(postID, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: Vote: cannot find post: %s\n", err)
 		misc.InternalError(w)
 		return
-	} else if !exist {
+	} else if post == nil || post.Deleted != nil {
 		http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
 		return
 	}
@@ -358,6 +863,9 @@ func (ph *PostHandler) Vote(w http.ResponseWriter, r *http.Request) {
 		misc.InternalError(w)
 		return
 	}
+	if !checkCategoryBan(w, r, ph.Bans, post.Category, user, "path", "Vote") {
+		return
+	}
 	switch path.Base(r.URL.Path) {
 	case "upvote":
 		err = ph.Storage.Rate(postID, 1, user, ctx)
@@ -371,8 +879,15 @@ func (ph *PostHandler) Vote(w http.ResponseWriter, r *http.Request) {
 		misc.InternalError(w)
 		return
 	}
+	publishPost(ph.Events, events.PostVoted, post, postID, "")
 
-	data, err := ph.Storage.GetPost(postID, ctx)
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: Vote: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPost(postID, viewer, ctx)
 	if err != nil {
 		log.Printf("handlers/posts.go: Vote: cannot get post: %s\n", err)
 		misc.InternalError(w)
@@ -381,3 +896,71 @@ func (ph *PostHandler) Vote(w http.ResponseWriter, r *http.Request) {
 	dataRaw, _ := json.Marshal(data)
 	w.Write(dataRaw)
 }
+
+func (ph *PostHandler) VoteComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	postID, okPost := vars["post_id"]
+	commentID, okComment := vars["comment_id"]
+	if !okPost || !okComment {
+		log.Printf("handlers/posts.go: VoteComment: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	post, err := ph.Storage.FindPost(postID, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: VoteComment: cannot find post: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if post == nil || post.Deleted != nil {
+		http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
+		return
+	}
+	if status, err := ph.Storage.CheckCommentExist(postID, commentID, ctx); err != nil {
+		log.Printf("handlers/posts.go: VoteComment: cannot check comment existance: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if status != storage.StatusCommentOK {
+		http.Error(w, misc.FormMessage("comment not found"), http.StatusNotFound)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: VoteComment: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	if !checkCategoryBan(w, r, ph.Bans, post.Category, user, "path", "VoteComment") {
+		return
+	}
+	switch path.Base(r.URL.Path) {
+	case "upvote":
+		err = ph.Storage.RateComment(postID, commentID, 1, user, ctx)
+	case "downvote":
+		err = ph.Storage.RateComment(postID, commentID, -1, user, ctx)
+	case "unvote":
+		err = ph.Storage.UnrateComment(postID, commentID, user, ctx)
+	}
+	if err != nil {
+		log.Printf("handlers/posts.go: VoteComment: cannot vote: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	publishPost(ph.Events, events.CommentVoted, post, postID, commentID)
+
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/posts.go: VoteComment: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.GetPost(postID, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/posts.go: VoteComment: cannot get post: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
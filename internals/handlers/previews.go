@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"reddit_clone/internals/events"
+	"reddit_clone/internals/previews"
+)
+
+// waiting for a free fetcher slot included
+const previewTimeout = time.Minute
+
+// preview is fetched in background, nobody waits for someone else's slow site to post a link;
+// clients learn about it from the post updated event
+func (ph *PostHandler) fetchPreview(postID, link string) {
+	if ph.Previews == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+		defer cancel()
+		preview, err := ph.Previews.Fetch(link, ctx)
+		if errors.Is(err, previews.ErrNoPreview) {
+			return
+		} else if err != nil {
+			log.Printf("handlers/previews.go: fetchPreview: cannot fetch preview of %s: %s\n", link, err)
+			return
+		}
+		if err := ph.Storage.SetPreview(postID, *preview, ctx); err != nil {
+			log.Printf("handlers/previews.go: fetchPreview: cannot set preview: %s\n", err)
+			return
+		}
+		ph.publish(events.PostUpdated, postID, "", ctx)
+	}()
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"reddit_clone/internals/events"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const maxReasonLen = 256
+
+type Resolve struct {
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+//-------------------------------------Make reports------------------------------------//
+
+func (mh *ModHandler) ReportPost(w http.ResponseWriter, r *http.Request) {
+	mh.report(w, r, "ReportPost")
+}
+
+func (mh *ModHandler) ReportComment(w http.ResponseWriter, r *http.Request) {
+	mh.report(w, r, "ReportComment")
+}
+
+// comment_id can be absent in route, then report is about the post
+func (mh *ModHandler) report(w http.ResponseWriter, r *http.Request, funcName string) {
+	ctx := r.Context()
+	postID, ok := mux.Vars(r)["post_id"]
+	if !ok {
+		log.Printf("handlers/reports.go: %s: bad routing: %s\n", funcName, r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	commentID := mux.Vars(r)["comment_id"]
+	post, err := mh.Posts.FindPost(postID, ctx)
+	if err != nil {
+		log.Printf("handlers/reports.go: %s: cannot find post: %s\n", funcName, err)
+		misc.InternalError(w)
+		return
+	} else if post == nil || post.Deleted != nil {
+		http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
+		return
+	}
+	var comment *storage.Comment
+	if commentID != "" {
+		if comment, ok = findComment(w, r, post); !ok {
+			return
+		} else if comment.Deleted != nil {
+			http.Error(w, misc.FormMessage("comment not found"), http.StatusNotFound)
+			return
+		}
+	}
+
+	reason := &Reason{}
+	if err := json.NewDecoder(r.Body).Decode(reason); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	if len(reason.Reason) == 0 {
+		http.Error(w, misc.FormError("body", "reason", "", "cannot be blank"), http.StatusUnprocessableEntity)
+		return
+	} else if len(reason.Reason) > maxReasonLen {
+		errStr := fmt.Sprintf("must be at most %d characters long", maxReasonLen)
+		http.Error(w, misc.FormError("body", "reason", reason.Reason, errStr), http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/reports.go: %s: cannot get user: %s\n", funcName, err)
+		misc.InternalError(w)
+		return
+	}
+	if reported, err := mh.Reports.CheckReported(postID, commentID, user, ctx); err != nil {
+		log.Printf("handlers/reports.go: %s: cannot check report existance: %s\n", funcName, err)
+		misc.InternalError(w)
+		return
+	} else if reported {
+		http.Error(w, misc.FormMessage("already reported"), http.StatusConflict)
+		return
+	}
+	report := &storage.Report{
+		Category:  post.Category,
+		PostID:    postID,
+		CommentID: commentID,
+		Reporter:  storage.Author{Username: user.Username, ID: user.UserID},
+		Reason:    reason.Reason,
+		Created:   time.Now(),
+	}
+	if err := mh.Reports.MakeReport(report, ctx); err != nil {
+		log.Printf("handlers/reports.go: %s: cannot make report: %s\n", funcName, err)
+		misc.InternalError(w)
+		return
+	}
+	// report is already made, so failed automod checks do not fail the request, a retry would be a conflict
+	if err := mh.Automod.CheckReport(postID, commentID, post, comment, ctx); err != nil {
+		log.Printf("handlers/reports.go: %s: cannot check automod rules: %s\n", funcName, err)
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(misc.FormMessage("success")))
+}
+
+//-------------------------------------Moderation queue--------------------------------//
+
+func (mh *ModHandler) GetQueue(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	category, ok := mux.Vars(r)["category"]
+	if !ok {
+		log.Printf("handlers/reports.go: GetQueue: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	if _, ok := mh.checkModerator(w, r, category, "GetQueue"); !ok {
+		return
+	}
+	data, err := mh.Reports.GetQueue(category, ctx)
+	if err != nil {
+		log.Printf("handlers/reports.go: GetQueue: cannot get queue: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+// approve restores removed target, remove soft deletes it, ignore leaves it as is;
+// in any case all unresolved reports about the target are resolved
+func (mh *ModHandler) ResolveReports(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	category, categoryOK := vars["category"]
+	postID, postOK := vars["post_id"]
+	commentID := vars["comment_id"]
+	if !categoryOK || !postOK {
+		log.Printf("handlers/reports.go: ResolveReports: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	user, ok := mh.checkModerator(w, r, category, "ResolveReports")
+	if !ok {
+		return
+	}
+
+	resolve := &Resolve{}
+	if err := json.NewDecoder(r.Body).Decode(resolve); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	switch resolve.Action {
+	case storage.ResolutionApprove, storage.ResolutionRemove, storage.ResolutionIgnore:
+	default:
+		http.Error(w, misc.FormError("body", "action", resolve.Action, "must be approve, remove or ignore"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	post, err := mh.Posts.FindPost(postID, ctx)
+	if err != nil {
+		log.Printf("handlers/reports.go: ResolveReports: cannot find post: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if post == nil || post.Category != category {
+		http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
+		return
+	}
+	author, deleted := post.Author, post.Deleted
+	if commentID != "" {
+		comment, ok := findComment(w, r, post)
+		if !ok {
+			return
+		}
+		author, deleted = comment.Author, comment.Deleted
+	}
+
+	action := ""
+	switch {
+	case resolve.Action == storage.ResolutionApprove && !(deleted != nil && deleted.By.ID == author.ID):
+		if commentID != "" {
+			action = storage.ModActionApproveComment
+			if deleted != nil {
+				action = storage.ModActionRestoreComment
+			}
+		} else {
+			action = storage.ModActionApprovePost
+			if deleted != nil {
+				action = storage.ModActionRestorePost
+			}
+		}
+	case resolve.Action == storage.ResolutionRemove && deleted == nil:
+		action = storage.ModActionRemovePost
+		if commentID != "" {
+			action = storage.ModActionRemoveComment
+		}
+	}
+	if action != "" {
+		entry := newModLogEntry(category, user, action, postTarget(r), resolve.Reason)
+		if !mh.logAction(w, r, entry, "ResolveReports") {
+			return
+		}
+		switch action {
+		case storage.ModActionApproveComment, storage.ModActionRestoreComment:
+			err = mh.Posts.ApproveComment(postID, commentID, approvalBy(user), ctx)
+		case storage.ModActionApprovePost, storage.ModActionRestorePost:
+			err = mh.Posts.ApprovePost(postID, approvalBy(user), ctx)
+		case storage.ModActionRemoveComment:
+			err = mh.Posts.DeleteComment(postID, commentID, deletionBy(user, resolve.Reason), ctx)
+		case storage.ModActionRemovePost:
+			err = mh.Posts.DeletePost(postID, deletionBy(user, resolve.Reason), ctx)
+		}
+		if err != nil {
+			log.Printf("handlers/reports.go: ResolveReports: cannot %s: %s\n", resolve.Action, err)
+			mh.unlogAction(entry, r, "ResolveReports")
+			misc.InternalError(w)
+			return
+		}
+	}
+	switch action {
+	case storage.ModActionRemovePost:
+		mh.dispatch(events.PostDeleted, postID, "", ctx)
+	case storage.ModActionRemoveComment:
+		mh.dispatch(events.CommentDeleted, postID, commentID, ctx)
+	}
+
+	resolution := storage.Resolution{
+		Action: resolve.Action,
+		By:     storage.Author{Username: user.Username, ID: user.UserID},
+		At:     time.Now(),
+		Reason: resolve.Reason,
+	}
+	if err := mh.Reports.ResolveReports(postID, commentID, resolution, ctx); err != nil {
+		log.Printf("handlers/reports.go: ResolveReports: cannot resolve reports: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	w.Write([]byte(misc.FormMessage("success")))
+}
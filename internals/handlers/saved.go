@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"unicode/utf8"
+
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const maxFolderLen = 32
+
+type SaveRequest struct {
+	Folder string `json:"folder"`
+}
+
+// saves or unsaves post, or comment if there is comment_id in path;
+// unsaving does not need the content to exist, so unavailable saves can be dropped
+func (ph *PostHandler) Save(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	postID, ok := vars["post_id"]
+	if !ok {
+		log.Printf("handlers/saved.go: Save: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	commentID := vars["comment_id"]
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/saved.go: Save: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+
+	if path.Base(r.URL.Path) == "unsave" {
+		if err := ph.Saved.Unsave(postID, commentID, user, ctx); err != nil {
+			log.Printf("handlers/saved.go: Save: cannot unsave: %s\n", err)
+			misc.InternalError(w)
+			return
+		}
+		w.Write([]byte(misc.FormMessage("success")))
+		return
+	}
+
+	request := &SaveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil && err != io.EOF {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(request.Folder) > maxFolderLen {
+		errors := misc.NewErrorBuilder()
+		errStr := fmt.Sprintf("must be at most %d characters long", maxFolderLen)
+		errors.Add("body", "folder", request.Folder, errStr)
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if commentID == "" {
+		if exist, err := ph.Storage.CheckPostExist(postID, ctx); err != nil {
+			log.Printf("handlers/saved.go: Save: cannot check post existance: %s\n", err)
+			misc.InternalError(w)
+			return
+		} else if !exist {
+			http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
+			return
+		}
+	} else {
+		if status, err := ph.Storage.CheckCommentExist(postID, commentID, ctx); err != nil {
+			log.Printf("handlers/saved.go: Save: cannot check comment existance: %s\n", err)
+			misc.InternalError(w)
+			return
+		} else if status == storage.StatusPostNotExist {
+			http.Error(w, misc.FormMessage("post not found"), http.StatusNotFound)
+			return
+		} else if status != storage.StatusCommentOK {
+			http.Error(w, misc.FormMessage("comment not found"), http.StatusNotFound)
+			return
+		}
+	}
+	item := storage.SavedItem{PostID: postID, CommentID: commentID, Folder: request.Folder}
+	if err := ph.Saved.Save(item, user, ctx); err != nil {
+		log.Printf("handlers/saved.go: Save: cannot save: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	w.Write([]byte(misc.FormMessage("success")))
+}
+
+// saved items of the user; deleted or hidden content is marked unavailable
+func (ph *PostHandler) GetSaved(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	errors := misc.NewErrorBuilder()
+	opts := parseListOptions(r, errors)
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/saved.go: GetSaved: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	items, err := ph.Saved.GetSaved(r.URL.Query().Get("folder"), opts, user, ctx)
+	if err != nil {
+		log.Printf("handlers/saved.go: GetSaved: cannot get saved: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/saved.go: GetSaved: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	postIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		postIDs = append(postIDs, item.PostID)
+	}
+	posts, err := ph.Storage.GetPostsByIDs(postIDs, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/saved.go: GetSaved: cannot get posts: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	byID := make(map[string]*storage.Post, len(posts))
+	for _, post := range posts {
+		byID[hex.EncodeToString(post.ID)] = post
+	}
+	for _, item := range items {
+		post, ok := byID[item.PostID]
+		if !ok {
+			item.Unavailable = true
+			continue
+		}
+		if item.CommentID != "" {
+			item.Comment = findSavedComment(post, item.CommentID)
+			if item.Comment == nil {
+				item.Unavailable = true
+				continue
+			}
+		}
+		// comments of saved post are not listed, they can be got with the post itself
+		listed := *post
+		listed.Comments = nil
+		item.Post = &listed
+	}
+	dataRaw, _ := json.Marshal(items)
+	w.Write(dataRaw)
+}
+
+// returns nil if comment was deleted or its author is hidden
+func findSavedComment(post *storage.Post, commentID string) *storage.Comment {
+	for i := range post.Comments {
+		comment := &post.Comments[i]
+		if hex.EncodeToString(comment.ID) == commentID && comment.Deleted == nil {
+			return comment
+		}
+	}
+	return nil
+}
+
+func (ph *PostHandler) GetSavedFolders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, err := GetUser(r)
+	if err != nil {
+		log.Printf("handlers/saved.go: GetSavedFolders: cannot get user: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Saved.GetFolders(user, ctx)
+	if err != nil {
+		log.Printf("handlers/saved.go: GetSavedFolders: cannot get folders: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
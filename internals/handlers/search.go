@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+)
+
+const maxQueryLen = 256
+
+// parses search query and its filters, bad values are added to errors
+func parseSearchOptions(r *http.Request, errors *misc.ErrorBuilder) storage.SearchOptions {
+	query := r.URL.Query()
+	listOpts := parseListOptions(r, errors)
+	opts := storage.SearchOptions{
+		Query:    query.Get("q"),
+		Category: query.Get("category"),
+		Author:   query.Get("author"),
+		Type:     query.Get("type"),
+		Limit:    listOpts.Limit,
+		Offset:   listOpts.Offset,
+	}
+
+	if len(opts.Query) == 0 {
+		errors.Add("query", "q", opts.Query, "is required")
+	} else if len(opts.Query) > maxQueryLen {
+		errStr := fmt.Sprintf("must be at most %d characters long", maxQueryLen)
+		errors.Add("query", "q", opts.Query, errStr)
+	}
+	if opts.Type != "" && opts.Type != "text" && opts.Type != "link" && opts.Type != "image" && opts.Type != "poll" {
+		errors.Add("query", "type", opts.Type, "must be text, link, image or poll")
+	}
+	for _, param := range []string{"since", "until"} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			errors.Add("query", param, value, "must be a RFC3339 date")
+			continue
+		}
+		if param == "since" {
+			opts.Since = date
+		} else {
+			opts.Until = date
+		}
+	}
+	return opts
+}
+
+func (ph *PostHandler) Search(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	errors := misc.NewErrorBuilder()
+	opts := parseSearchOptions(r, errors)
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	viewer, err := ph.viewer(r)
+	if err != nil {
+		log.Printf("handlers/search.go: Search: cannot get viewer: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	data, err := ph.Storage.Search(opts, viewer, ctx)
+	if err != nil {
+		log.Printf("handlers/search.go: Search: cannot search posts: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+
+	"github.com/gorilla/mux"
+)
+
+type UserHandler struct {
+	Storage storage.UserStorage
+	Users   storage.AuthStorage
+	Hides   storage.HideStorage
+}
+
+func (uh *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username, ok := mux.Vars(r)["username"]
+	if !ok {
+		log.Printf("handlers/users.go: GetProfile: bad routing: %s\n", r.URL.Path)
+		misc.InternalError(w)
+		return
+	}
+	profile, err := uh.Storage.GetProfile(username, ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, misc.FormMessage("user not exist"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("handlers/users.go: GetProfile: cannot get profile: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(profile)
+	w.Write(dataRaw)
+}
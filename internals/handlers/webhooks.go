@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"reddit_clone/internals/events"
+	"reddit_clone/internals/misc"
+	"reddit_clone/internals/storage"
+	"reddit_clone/internals/webhooks"
+
+	"github.com/gorilla/mux"
+)
+
+const minSecretLen = 16
+
+var webhookEvents = map[string]struct{}{
+	events.PostCreated:    {},
+	events.PostDeleted:    {},
+	events.CommentCreated: {},
+	events.CommentDeleted: {},
+}
+
+func (ph *PostHandler) dispatch(event, postID, commentID string, ctx context.Context) {
+	dispatch(ph.Webhooks, ph.Storage, event, postID, commentID, ctx)
+}
+
+// moderators remove content too, and webhooks are told about it the same way
+func (mh *ModHandler) dispatch(event, postID, commentID string, ctx context.Context) {
+	dispatch(mh.Webhooks, mh.Posts, event, postID, commentID, ctx)
+}
+
+// queues webhook deliveries for the change; content which nobody else can see is not sent,
+// and like events, webhooks never fail the request
+func dispatch(dispatcher *webhooks.Dispatcher, posts storage.PostStorage, event, postID, commentID string, ctx context.Context) {
+	if dispatcher == nil {
+		return
+	}
+	post, err := posts.FindPost(postID, ctx)
+	if err != nil || post == nil {
+		log.Printf("handlers/webhooks.go: dispatch: cannot find post: %v\n", err)
+		return
+	}
+	payload := webhooks.Payload{
+		Event:     event,
+		Category:  post.Category,
+		PostID:    postID,
+		CommentID: commentID,
+		Created:   time.Now(),
+	}
+	switch event {
+	case events.PostCreated:
+		if _, ok := visibleCreation(post, ""); !ok {
+			return
+		}
+		payload.Title, payload.URL, payload.Text = post.Title, post.URL, post.Text
+		payload.Author = post.Author.Username
+	case events.CommentCreated:
+		comment, ok := visibleCreation(post, commentID)
+		if !ok {
+			return
+		}
+		payload.Title, payload.Text = post.Title, comment.Body
+		payload.Author = comment.Author.Username
+	case events.CommentDeleted:
+		comment := postComment(post, commentID)
+		if comment == nil {
+			log.Printf("handlers/webhooks.go: dispatch: cannot find comment %s\n", commentID)
+			return
+		} else if comment.Shadow {
+			return
+		}
+	default:
+		if post.Shadow {
+			return
+		}
+	}
+	if err := dispatcher.Enqueue(payload, ctx); err != nil {
+		log.Printf("handlers/webhooks.go: dispatch: cannot enqueue: %s\n", err)
+	}
+}
+
+//----------------------------------------Admin----------------------------------------//
+
+type NewWebhook struct {
+	URL      string   `json:"url"`
+	Events   []string `json:"events"`
+	Category string   `json:"category"`
+	Secret   string   `json:"secret"` // generated if empty
+}
+
+// the only response with the secret in it
+func (ah *AdminHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := ah.checkAdmin(w, r, "CreateWebhook")
+	if !ok {
+		return
+	}
+	newWebhook := &NewWebhook{}
+	if err := json.NewDecoder(r.Body).Decode(newWebhook); err != nil {
+		http.Error(w, misc.FormMessage("bad request"), http.StatusBadRequest)
+		return
+	}
+	errors := misc.NewErrorBuilder()
+	if u, err := url.Parse(newWebhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errors.Add("body", "url", newWebhook.URL, "must be an http or https url")
+	}
+	if len(newWebhook.Events) == 0 {
+		errors.Add("body", "events", "", "is required")
+	}
+	for _, event := range newWebhook.Events {
+		if _, ok := webhookEvents[event]; !ok {
+			errors.Add("body", "events", event, "unknown event")
+		}
+	}
+	// empty category means all categories
+	if !misc.IsValidCategoryName(newWebhook.Category) {
+		errors.Add("body", "category", newWebhook.Category, "is not a valid category name")
+	} else if newWebhook.Category != "" {
+		if exist, err := ah.Categories.CheckCategoryExist(newWebhook.Category, r.Context()); err != nil {
+			log.Printf("handlers/webhooks.go: CreateWebhook: cannot check category existance: %s\n", err)
+			misc.InternalError(w)
+			return
+		} else if !exist {
+			errors.Add("body", "category", newWebhook.Category, "does not exist")
+		}
+	}
+	if newWebhook.Secret != "" && len(newWebhook.Secret) < minSecretLen {
+		errors.Add("body", "secret", "", "is too short")
+	}
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if newWebhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Printf("handlers/webhooks.go: CreateWebhook: cannot generate secret: %s\n", err)
+			misc.InternalError(w)
+			return
+		}
+		newWebhook.Secret = hex.EncodeToString(secret)
+	}
+
+	webhook := &storage.Webhook{
+		URL:       newWebhook.URL,
+		Events:    newWebhook.Events,
+		Category:  newWebhook.Category,
+		Secret:    newWebhook.Secret,
+		CreatedBy: storage.Author{Username: user.Username, ID: user.UserID},
+		Created:   time.Now(),
+	}
+	if err := ah.Webhooks.CreateWebhook(webhook, r.Context()); err != nil {
+		log.Printf("handlers/webhooks.go: CreateWebhook: cannot create webhook: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(webhook)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(dataRaw)
+}
+
+func (ah *AdminHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ah.checkAdmin(w, r, "GetWebhooks"); !ok {
+		return
+	}
+	data, err := ah.Webhooks.GetWebhooks(r.Context())
+	if err != nil {
+		log.Printf("handlers/webhooks.go: GetWebhooks: cannot get webhooks: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	for _, webhook := range data {
+		webhook.Secret = ""
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+// writes error response if there is no webhook with id from path
+func (ah *AdminHandler) findWebhook(w http.ResponseWriter, r *http.Request, funcName string) (*storage.Webhook, bool) {
+	webhook, err := ah.Webhooks.GetWebhook(mux.Vars(r)["webhook_id"], r.Context())
+	if err != nil {
+		log.Printf("handlers/webhooks.go: %s: cannot get webhook: %s\n", funcName, err)
+		misc.InternalError(w)
+		return nil, false
+	} else if webhook == nil {
+		http.Error(w, misc.FormMessage("webhook not found"), http.StatusNotFound)
+		return nil, false
+	}
+	return webhook, true
+}
+
+func (ah *AdminHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ah.checkAdmin(w, r, "DeleteWebhook"); !ok {
+		return
+	}
+	webhook, ok := ah.findWebhook(w, r, "DeleteWebhook")
+	if !ok {
+		return
+	}
+	if err := ah.Webhooks.DeleteWebhook(webhook.ID.Hex(), r.Context()); err != nil {
+		log.Printf("handlers/webhooks.go: DeleteWebhook: cannot delete webhook: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	w.Write([]byte(misc.FormMessage("success")))
+}
+
+// sends ping event to check that the receiver is reachable and checks signatures
+func (ah *AdminHandler) PingWebhook(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ah.checkAdmin(w, r, "PingWebhook"); !ok {
+		return
+	}
+	webhook, ok := ah.findWebhook(w, r, "PingWebhook")
+	if !ok {
+		return
+	}
+	if err := ah.Dispatcher.Ping(webhook, r.Context()); err != nil {
+		log.Printf("handlers/webhooks.go: PingWebhook: cannot ping: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(misc.FormMessage("success")))
+}
+
+// delivery log, ?status=dead lists the dead letters
+func (ah *AdminHandler) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ah.checkAdmin(w, r, "GetDeliveries"); !ok {
+		return
+	}
+	errors := misc.NewErrorBuilder()
+	opts := parseListOptions(r, errors)
+	status := r.URL.Query().Get("status")
+	if status != "" && status != storage.DeliveryPending && status != storage.DeliveryDelivered && status != storage.DeliveryDead {
+		errors.Add("query", "status", status, "must be pending, delivered or dead")
+	}
+	if !errors.Empty() {
+		http.Error(w, errors.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	webhook, ok := ah.findWebhook(w, r, "GetDeliveries")
+	if !ok {
+		return
+	}
+	data, err := ah.Webhooks.GetDeliveries(webhook.ID.Hex(), status, opts, r.Context())
+	if err != nil {
+		log.Printf("handlers/webhooks.go: GetDeliveries: cannot get deliveries: %s\n", err)
+		misc.InternalError(w)
+		return
+	}
+	dataRaw, _ := json.Marshal(data)
+	w.Write(dataRaw)
+}
+
+func (ah *AdminHandler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ah.checkAdmin(w, r, "RetryDelivery"); !ok {
+		return
+	}
+	webhook, ok := ah.findWebhook(w, r, "RetryDelivery")
+	if !ok {
+		return
+	}
+	deliveryID := mux.Vars(r)["delivery_id"]
+	if retried, err := ah.Webhooks.RetryDelivery(webhook.ID.Hex(), deliveryID, time.Now(), r.Context()); err != nil {
+		log.Printf("handlers/webhooks.go: RetryDelivery: cannot retry delivery: %s\n", err)
+		misc.InternalError(w)
+		return
+	} else if !retried {
+		http.Error(w, misc.FormMessage("dead delivery not found"), http.StatusNotFound)
+		return
+	}
+	ah.Dispatcher.Wake()
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(misc.FormMessage("success")))
+}
@@ -0,0 +1,317 @@
+// Package images checks uploaded images and re-encodes them. Re-encoding keeps only pixels,
+// so exif with camera and location data and any other metadata never reach the blob store;
+// jpeg orientation is applied to the pixels before it is dropped.
+package images
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupported = errors.New("only jpeg, png and gif images are supported")
+	ErrTooLarge    = errors.New("image dimensions are too large")
+)
+
+const (
+	MaxPixels    = 40_000_000 // decoded size is checked before decoding, so small files cannot blow up in memory
+	maxFrames    = 500
+	maxGIFPixels = 100_000_000 // all frames of animation are kept in memory, a byte per pixel
+	ThumbSize    = 320         // thumbnails fit into square with this side
+	jpegQuality  = 90
+	thumbQuality = 80
+)
+
+// extensions of supported types, type is sniffed from content and never taken from the upload
+var Extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+type Image struct {
+	ContentType string
+	Data        []byte
+	Width       int
+	Height      int
+	Thumb       []byte // always jpeg
+}
+
+// Processor limits how many images are decoded at once, each can take hundreds of megabytes
+type Processor struct {
+	slots chan struct{}
+}
+
+func NewProcessor(workers int) *Processor {
+	return &Processor{slots: make(chan struct{}, workers)}
+}
+
+func (p *Processor) Process(data []byte, ctx context.Context) (*Image, error) {
+	select {
+	case p.slots <- struct{}{}:
+		defer func() { <-p.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return process(data)
+}
+
+func process(data []byte) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := Extensions[contentType]; !ok {
+		return nil, ErrUnsupported
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	} else if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrUnsupported
+	} else if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	var img image.Image
+	buf := &bytes.Buffer{}
+	switch contentType {
+	case "image/jpeg":
+		if img, err = jpeg.Decode(bytes.NewReader(data)); err != nil {
+			return nil, ErrUnsupported
+		}
+		img = orient(img, jpegOrientation(data))
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		if img, err = png.Decode(bytes.NewReader(data)); err != nil {
+			return nil, ErrUnsupported
+		}
+		err = png.Encode(buf, img)
+	case "image/gif":
+		// frames are counted before decoding, decoder keeps all of them
+		if frames, pixels, countErr := countFrames(data); countErr != nil {
+			return nil, countErr
+		} else if frames > maxFrames || pixels > maxGIFPixels {
+			return nil, ErrTooLarge
+		}
+		anim, decodeErr := gif.DecodeAll(bytes.NewReader(data))
+		if decodeErr != nil || len(anim.Image) == 0 {
+			return nil, ErrUnsupported
+		}
+		// comments and application extensions other than looping are not written back
+		err = gif.EncodeAll(buf, anim)
+		canvas := image.NewRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+		draw.Draw(canvas, anim.Image[0].Bounds(), anim.Image[0], anim.Image[0].Bounds().Min, draw.Over)
+		img = canvas
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := &bytes.Buffer{}
+	if err := jpeg.Encode(thumb, thumbnail(img, ThumbSize), &jpeg.Options{Quality: thumbQuality}); err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	return &Image{
+		ContentType: contentType,
+		Data:        buf.Bytes(),
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Thumb:       thumb.Bytes(),
+	}, nil
+}
+
+// walks blocks of gif without decompressing them and sums sizes of frames
+func countFrames(data []byte) (frames, pixels int, err error) {
+	// header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, 0, ErrUnsupported
+	}
+	i := 13 + colorTableSize(data[10])
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: label and data sub-blocks
+			i += 2
+		case 0x2c: // image descriptor, optional local color table, lzw code size and data sub-blocks
+			if i+10 > len(data) {
+				return 0, 0, ErrUnsupported
+			}
+			width := int(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int(binary.LittleEndian.Uint16(data[i+7:]))
+			frames, pixels = frames+1, pixels+width*height
+			i += 10 + colorTableSize(data[i+9]) + 1
+		case 0x3b: // trailer
+			return frames, pixels, nil
+		default:
+			return 0, 0, ErrUnsupported
+		}
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		i++
+	}
+	return 0, 0, ErrUnsupported
+}
+
+func colorTableSize(flags byte) int {
+	if flags&0x80 == 0 {
+		return 0
+	}
+	return 3 << (flags&0x07 + 1)
+}
+
+//----------------------------------------Thumbnails----------------------------------------//
+
+// averages every block of source pixels into one, transparent parts end up white because thumbnails are jpeg
+func thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width > height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			// colors are premultiplied, so adding missing alpha puts them over white
+			white := 0xffff - a/n
+			thumb.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + white) >> 8),
+				G: uint8((g/n + white) >> 8),
+				B: uint8((b/n + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return thumb
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+//----------------------------------------Orientation----------------------------------------//
+
+// exif orientation from app1 segment of jpeg, 1 is upright and is returned whenever something is off
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 { // pixels or end of image, there is no exif
+			return 1
+		}
+		size := int(data[i+2])<<8 | int(data[i+3])
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// orientation tag 0x0112 of the first ifd
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var uint16At func(int) int
+	var uint32At func(int) int
+	switch string(tiff[:2]) {
+	case "II":
+		uint16At = func(i int) int { return int(tiff[i]) | int(tiff[i+1])<<8 }
+		uint32At = func(i int) int { return uint16At(i) | uint16At(i+2)<<16 }
+	case "MM":
+		uint16At = func(i int) int { return int(tiff[i])<<8 | int(tiff[i+1]) }
+		uint32At = func(i int) int { return uint16At(i)<<16 | uint16At(i+2) }
+	default:
+		return 1
+	}
+	ifd := uint32At(4)
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := uint16At(ifd)
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if uint16At(entry) == 0x0112 {
+			if orientation := uint16At(entry + 8); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// turns pixels the way exif orientation says they should be shown
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // needs clockwise turn
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs counterclockwise turn
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,168 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+)
+
+func encodeGIF(t *testing.T, frames, width, height int) []byte {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, width, height), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCountFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+		pixels int
+	}{
+		{"single frame", encodeGIF(t, 1, 10, 20), 1, 200},
+		{"animation", encodeGIF(t, 3, 4, 5), 3, 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, pixels, err := countFrames(tt.data)
+			if err != nil || frames != tt.frames || pixels != tt.pixels {
+				t.Errorf("countFrames() = %d, %d, %v, want %d, %d, nil", frames, pixels, err, tt.frames, tt.pixels)
+			}
+		})
+	}
+}
+
+func TestCountFramesMalformed(t *testing.T) {
+	valid := encodeGIF(t, 2, 8, 8)
+	// every cut loses the trailer, so nothing short of the whole file is accepted
+	for n := 0; n < len(valid); n++ {
+		if _, _, err := countFrames(valid[:n]); err != ErrUnsupported {
+			t.Errorf("countFrames() of first %d bytes = %v, want %v", n, err, ErrUnsupported)
+		}
+	}
+
+	header := valid[:13]
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown block", append(append([]byte{}, header...), 0x99, 0x3b)},
+		{"descriptor cut short", append(append([]byte{}, header...), 0x2c, 0, 0, 0, 0)},
+		{"sub-block longer than data", append(append([]byte{}, header...), 0x21, 0xf9, 0xff, 1, 2)},
+		{"extension without terminator", append(append([]byte{}, header...), 0x21, 0xfe, 3, 'a', 'b', 'c')},
+		{"color table past end", []byte("GIF89a\x01\x00\x01\x00\x87\x00\x00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := countFrames(tt.data); err != ErrUnsupported {
+				t.Errorf("countFrames() = %v, want %v", err, ErrUnsupported)
+			}
+		})
+	}
+}
+
+// tiff header with one ifd holding the orientation entry
+func exifTIFF(bigEndian bool, orientation int) []byte {
+	if bigEndian {
+		return []byte{
+			'M', 'M', 0, 42, 0, 0, 0, 8,
+			0, 1,
+			0x01, 0x12, 0, 3, 0, 0, 0, 1, byte(orientation >> 8), byte(orientation), 0, 0,
+			0, 0, 0, 0,
+		}
+	}
+	return []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0,
+		1, 0,
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), byte(orientation >> 8), 0, 0,
+		0, 0, 0, 0,
+	}
+}
+
+// jpeg start, app1 segment with exif and the start of scan
+func exifJPEG(tiff []byte) []byte {
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	size := len(segment) + 2
+	data := []byte{0xff, 0xd8, 0xff, 0xe1, byte(size >> 8), byte(size)}
+	data = append(data, segment...)
+	return append(data, 0xff, 0xda, 0, 2)
+}
+
+func TestTIFFOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"little endian", exifTIFF(false, 6), 6},
+		{"big endian", exifTIFF(true, 8), 8},
+		{"out of range", exifTIFF(false, 9), 1},
+		{"zero", exifTIFF(true, 0), 1},
+		{"empty", nil, 1},
+		{"unknown byte order", append([]byte("XX"), exifTIFF(false, 6)[2:]...), 1},
+		{"ifd past end", []byte{'I', 'I', 42, 0, 0xff, 0, 0, 0, 0, 0}, 1},
+		{"ifd inside header", []byte{'I', 'I', 42, 0, 2, 0, 0, 0, 1, 0}, 1},
+		{"more entries than data", []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0xff, 0xff, 0x01, 0x12}, 1},
+		{"no orientation entry", []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0, 0x0f, 0x01, 2, 0, 1, 0, 0, 0, 6, 0, 0, 0}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tiffOrientation(tt.tiff); got != tt.want {
+				t.Errorf("tiffOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	valid := exifTIFF(false, 6)
+	for n := 0; n < len(valid); n++ {
+		tiffOrientation(valid[:n])
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := &bytes.Buffer{}
+	if err := jpeg.Encode(plain, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	withExif := exifJPEG(exifTIFF(true, 6))
+	otherApp1 := append([]byte{0xff, 0xd8, 0xff, 0xe1, 0, 6, 'h', 't', 't', 'p'}, withExif[2:]...)
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"exif", withExif, 6},
+		{"exif after other app1", otherApp1, 6},
+		{"no exif", plain.Bytes(), 1},
+		{"not a jpeg", []byte("GIF89a"), 1},
+		{"empty", nil, 1},
+		{"segment longer than data", []byte{0xff, 0xd8, 0xff, 0xe1, 0xff, 0xff, 'E', 'x'}, 1},
+		{"segment size below minimum", []byte{0xff, 0xd8, 0xff, 0xe1, 0, 1, 0xff, 0xda}, 1},
+		{"garbage instead of marker", []byte{0xff, 0xd8, 0x00, 0xe1, 0, 4, 0, 0}, 1},
+		{"exif after scan", append([]byte{0xff, 0xd8, 0xff, 0xda, 0, 2}, withExif[2:]...), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// cut exif must never be read past its end
+	for n := 0; n < len(withExif); n++ {
+		jpegOrientation(withExif[:n])
+	}
+}
@@ -0,0 +1,605 @@
+// Package markdown renders a safe subset of markdown: paragraphs, emphasis, strikethrough,
+// inline and fenced code, links, quotes and lists. Raw html is never passed through,
+// all text is escaped and only http(s), mailto and site relative links are kept,
+// so the output can be put into pages as is.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"strings"
+)
+
+// nesting of quotes, lists and emphasis; deeper markup is rendered as plain text
+const maxDepth = 8
+
+func Render(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	buf := &strings.Builder{}
+	renderBlocks(buf, strings.Split(source, "\n"), 0)
+	return buf.String()
+}
+
+//----------------------------------------Blocks----------------------------------------//
+
+func renderBlocks(buf *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case isFence(line):
+			i = renderCode(buf, lines, i)
+		case isQuote(line) && depth < maxDepth:
+			i = renderQuote(buf, lines, i, depth)
+		case isListItem(line) && depth < maxDepth:
+			i = renderList(buf, lines, i, depth)
+		default:
+			i = renderParagraph(buf, lines, i)
+		}
+	}
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// block markers can be indented by up to three spaces
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func unindent(line string, n int) string {
+	if indent := indentOf(line); indent < n {
+		n = indent
+	}
+	return line[n:]
+}
+
+func startsBlock(line string) bool {
+	if isFence(line) || isQuote(line) {
+		return true
+	}
+	// only lists starting from 1 can interrupt a paragraph, so "2021. was a year" stays text
+	item, ok := parseListItem(line)
+	return ok && (!item.ordered || item.start == 1)
+}
+
+func renderParagraph(buf *strings.Builder, lines []string, i int) int {
+	text := []string{strings.TrimLeft(lines[i], " ")}
+	for i++; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+		text = append(text, strings.TrimLeft(lines[i], " "))
+	}
+	buf.WriteString("<p>")
+	renderInline(buf, strings.TrimRight(strings.Join(text, "\n"), " "), 0, false)
+	buf.WriteString("</p>\n")
+	return i
+}
+
+//----------------------------------------Code----------------------------------------//
+
+func isFence(line string) bool {
+	if indentOf(line) > 3 {
+		return false
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+}
+
+// fenced code runs until the same fence or the end of text
+func renderCode(buf *strings.Builder, lines []string, i int) int {
+	indent := indentOf(lines[i])
+	opening := lines[i][indent:]
+	fence := opening[:len(opening)-len(strings.TrimLeft(opening, opening[:1]))]
+	lang := language(strings.TrimSpace(opening[len(fence):]))
+
+	code := []string{}
+	for i++; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		code = append(code, unindent(lines[i], indent))
+	}
+
+	buf.WriteString("<pre><code")
+	if lang != "" {
+		buf.WriteString(` class="language-` + lang + `"`)
+	}
+	buf.WriteString(">")
+	for _, line := range code {
+		buf.WriteString(html.EscapeString(line))
+		buf.WriteString("\n")
+	}
+	buf.WriteString("</code></pre>\n")
+	return i
+}
+
+// language goes to class attribute, so only plain names are kept
+func language(info string) string {
+	if fields := strings.Fields(info); len(fields) > 0 {
+		info = fields[0]
+	}
+	if len(info) > 20 {
+		return ""
+	}
+	for _, c := range info {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '+') {
+			return ""
+		}
+	}
+	return strings.ToLower(info)
+}
+
+//----------------------------------------Quotes----------------------------------------//
+
+func isQuote(line string) bool {
+	return indentOf(line) <= 3 && strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+func renderQuote(buf *strings.Builder, lines []string, i, depth int) int {
+	quoted := []string{}
+	for ; i < len(lines) && isQuote(lines[i]); i++ {
+		line := strings.TrimPrefix(strings.TrimLeft(lines[i], " "), ">")
+		quoted = append(quoted, strings.TrimPrefix(line, " "))
+	}
+	buf.WriteString("<blockquote>\n")
+	renderBlocks(buf, quoted, depth+1)
+	buf.WriteString("</blockquote>\n")
+	return i
+}
+
+//----------------------------------------Lists----------------------------------------//
+
+type listItem struct {
+	ordered bool
+	marker  byte // bullet or delimiter after number
+	start   int
+	indent  int // where content starts, continuation lines are indented at least that much
+	content string
+}
+
+func isListItem(line string) bool {
+	_, ok := parseListItem(line)
+	return ok
+}
+
+func parseListItem(line string) (listItem, bool) {
+	lead := indentOf(line)
+	if lead > 3 {
+		return listItem{}, false
+	}
+	rest := line[lead:]
+	item := listItem{}
+	n := 0
+	if rest != "" && strings.IndexByte("-*+", rest[0]) != -1 {
+		item.marker, n = rest[0], 1
+	} else {
+		for n < len(rest) && n < 9 && rest[n] >= '0' && rest[n] <= '9' {
+			item.start = item.start*10 + int(rest[n]-'0')
+			n++
+		}
+		if n == 0 || n == len(rest) || (rest[n] != '.' && rest[n] != ')') {
+			return listItem{}, false
+		}
+		item.ordered, item.marker = true, rest[n]
+		n++
+	}
+	if n < len(rest) && rest[n] != ' ' {
+		return listItem{}, false
+	}
+	content := strings.TrimLeft(rest[n:], " ")
+	if content == "" {
+		// empty bullet would turn "---" lines and lone numbers into lists
+		return listItem{}, false
+	}
+	item.indent = lead + n + 1
+	item.content = content
+	return item, true
+}
+
+func renderList(buf *strings.Builder, lines []string, i, depth int) int {
+	first, _ := parseListItem(lines[i])
+	items := [][]string{}
+	current := first
+	for i < len(lines) {
+		line := lines[i]
+		if isBlank(line) {
+			// blank line ends the list unless it goes on after it
+			j := i + 1
+			for j < len(lines) && isBlank(lines[j]) {
+				j++
+			}
+			if j == len(lines) {
+				break
+			}
+			next, ok := parseListItem(lines[j])
+			sameList := ok && next.ordered == first.ordered && next.marker == first.marker
+			if !sameList && indentOf(lines[j]) < current.indent {
+				break
+			}
+			for ; i < j; i++ {
+				items[len(items)-1] = append(items[len(items)-1], "")
+			}
+			continue
+		}
+		if len(items) > 0 && indentOf(line) >= current.indent {
+			items[len(items)-1] = append(items[len(items)-1], line[current.indent:])
+			i++
+			continue
+		}
+		if item, ok := parseListItem(line); ok && item.ordered == first.ordered && item.marker == first.marker {
+			current = item
+			items = append(items, []string{item.content})
+			i++
+			continue
+		}
+		// lazy continuation of the item text
+		last := items[len(items)-1]
+		if startsBlock(line) || last[len(last)-1] == "" {
+			break
+		}
+		items[len(items)-1] = append(last, strings.TrimLeft(line, " "))
+		i++
+	}
+
+	if first.ordered {
+		if first.start != 1 {
+			buf.WriteString(`<ol start="` + itoa(first.start) + `">` + "\n")
+		} else {
+			buf.WriteString("<ol>\n")
+		}
+	} else {
+		buf.WriteString("<ul>\n")
+	}
+	for _, item := range items {
+		buf.WriteString("<li>")
+		renderItem(buf, item, depth+1)
+		buf.WriteString("</li>\n")
+	}
+	if first.ordered {
+		buf.WriteString("</ol>\n")
+	} else {
+		buf.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// leading text of the item goes without paragraph, nested blocks follow it
+func renderItem(buf *strings.Builder, lines []string, depth int) {
+	n := 0
+	for n < len(lines) && !isBlank(lines[n]) && (n == 0 || !startsBlock(lines[n])) {
+		n++
+	}
+	if isFence(lines[0]) || isQuote(lines[0]) {
+		n = 0
+	}
+	if n > 0 {
+		renderInline(buf, strings.TrimRight(strings.Join(lines[:n], "\n"), " "), 0, false)
+	}
+	if n < len(lines) {
+		buf.WriteString("\n")
+		renderBlocks(buf, lines[n:], depth)
+	}
+}
+
+func itoa(n int) string {
+	if n == 0 {
+		return "0"
+	}
+	digits := []byte{}
+	for ; n > 0; n /= 10 {
+		digits = append([]byte{byte('0' + n%10)}, digits...)
+	}
+	return string(digits)
+}
+
+//----------------------------------------Inline----------------------------------------//
+
+// inline renders one piece of text. Closing brackets, parens and backtick runs are indexed
+// in one pass and closing emphasis is looked for with cursors which only move forward,
+// so no markup makes the rest of the text scanned again and rendering stays linear
+type inline struct {
+	buf    *strings.Builder
+	text   string
+	depth  int
+	inLink bool // forbids links inside link text
+
+	match   []int         // ']' closing '[' and ')' closing '(' on the same line, -1 if none
+	ticks   map[int][]int // starts of backtick runs by their length
+	tickPos map[int]int   // runs of the length which are behind already
+	closers [3][2]int     // last found closing run of '*', '_' and '~' one and two long
+}
+
+func renderInline(buf *strings.Builder, text string, depth int, inLink bool) {
+	in := &inline{buf: buf, text: text, depth: depth, inLink: inLink}
+	in.index()
+	in.render()
+}
+
+func (in *inline) index() {
+	text := in.text
+	in.match = make([]int, len(text))
+	in.ticks = make(map[int][]int)
+	in.tickPos = make(map[int]int)
+	for i := range in.match {
+		in.match[i] = -1
+	}
+	for k := range in.closers {
+		in.closers[k] = [2]int{-1, -1}
+	}
+
+	brackets, parens := []int{}, []int{}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			brackets = append(brackets, i)
+		case ']':
+			if n := len(brackets); n > 0 {
+				in.match[brackets[n-1]] = i
+				brackets = brackets[:n-1]
+			}
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			parens = parens[:0]
+		case '(':
+			parens = append(parens, i)
+		case ')':
+			if n := len(parens); n > 0 {
+				in.match[parens[n-1]] = i
+				parens = parens[:n-1]
+			}
+		case '`':
+			if i == 0 || text[i-1] != '`' {
+				n := runLength(text, i, '`')
+				in.ticks[n] = append(in.ticks[n], i)
+			}
+		}
+	}
+}
+
+func (in *inline) render() {
+	text, buf := in.text, in.buf
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && isPunct(text[i+1]):
+			writeEscaped(buf, text[i+1])
+			i += 2
+		case c == '`':
+			i = in.renderCodeSpan(i)
+		case c == '[' && !in.inLink:
+			i = in.renderLink(i)
+		case (c == '*' || c == '_' || c == '~') && in.depth < maxDepth:
+			i = in.renderEmphasis(i)
+		case c == 'h' && !in.inLink && wordStart(text, i) &&
+			(strings.HasPrefix(text[i:], "http://") || strings.HasPrefix(text[i:], "https://")):
+			i = renderAutolink(buf, text, i)
+		case c == ' ':
+			// two spaces at the end of line break it
+			j := i
+			for j < len(text) && text[j] == ' ' {
+				j++
+			}
+			if j-i >= 2 && j < len(text) && text[j] == '\n' {
+				buf.WriteString("<br>\n")
+				i = j + 1
+			} else {
+				buf.WriteString(text[i:j])
+				i = j
+			}
+		default:
+			writeEscaped(buf, c)
+			i++
+		}
+	}
+}
+
+func writeEscaped(buf *strings.Builder, c byte) {
+	switch c {
+	case '<':
+		buf.WriteString("&lt;")
+	case '>':
+		buf.WriteString("&gt;")
+	case '&':
+		buf.WriteString("&amp;")
+	case '"':
+		buf.WriteString("&#34;")
+	case '\'':
+		buf.WriteString("&#39;")
+	default:
+		buf.WriteByte(c)
+	}
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) != -1
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t'
+}
+
+func wordStart(text string, i int) bool {
+	return i == 0 || isSpace(text[i-1]) || text[i-1] == '('
+}
+
+// code span is closed by the same number of backticks, unclosed ones are plain text
+
+// code span is closed by the same number of backticks, unclosed ones are plain text
+func (in *inline) renderCodeSpan(i int) int {
+	text := in.text
+	n := runLength(text, i, '`')
+	runs, k := in.ticks[n], in.tickPos[n]
+	for k < len(runs) && runs[k] < i+n {
+		k++
+	}
+	in.tickPos[n] = k
+	if k == len(runs) {
+		in.buf.WriteString(text[i : i+n])
+		return i + n
+	}
+	j := runs[k]
+	code := strings.ReplaceAll(text[i+n:j], "\n", " ")
+	if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+		code = code[1 : len(code)-1]
+	}
+	in.buf.WriteString("<code>" + html.EscapeString(code) + "</code>")
+	return j + n
+}
+
+func runLength(text string, i int, c byte) int {
+	n := 0
+	for i+n < len(text) && text[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// [text](url), links which are not safe keep only their text
+func (in *inline) renderLink(i int) int {
+	label, target, end, ok := in.parseLink(i)
+	if !ok {
+		in.buf.WriteByte('[')
+		return i + 1
+	}
+	if href, safe := safeURL(target); safe {
+		in.buf.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow ugc">`)
+		renderInline(in.buf, label, in.depth+1, true)
+		in.buf.WriteString("</a>")
+	} else {
+		renderInline(in.buf, label, in.depth+1, true)
+	}
+	return end
+}
+
+func (in *inline) parseLink(i int) (label, target string, end int, ok bool) {
+	j := in.match[i]
+	if j == -1 || j+1 == len(in.text) || in.text[j+1] != '(' {
+		return "", "", 0, false
+	}
+	k := in.match[j+1]
+	if k == -1 {
+		return "", "", 0, false
+	}
+	label = in.text[i+1 : j]
+	// title after the url is allowed and ignored
+	fields := strings.Fields(in.text[j+2 : k])
+	if len(fields) == 0 || label == "" {
+		return "", "", 0, false
+	}
+	return label, fields[0], k + 1, true
+}
+
+// http(s) and mailto links and links within the site, anything else could run scripts
+func safeURL(raw string) (string, bool) {
+	for _, c := range raw {
+		if c < ' ' || c == 0x7f {
+			return "", false
+		}
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		if parsed.Host == "" {
+			return "", false
+		}
+	case "mailto":
+	case "":
+		if strings.HasPrefix(raw, "//") || !(strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "#")) {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+func renderAutolink(buf *strings.Builder, text string, i int) int {
+	j := i
+	for j < len(text) && !isSpace(text[j]) && text[j] != '<' {
+		j++
+	}
+	// trailing punctuation most likely ends the sentence, not the url
+	for j > i && strings.IndexByte(".,;:!?'\")*_~", text[j-1]) != -1 {
+		j--
+	}
+	link := text[i:j]
+	href, safe := safeURL(link)
+	if !safe {
+		writeEscaped(buf, text[i])
+		return i + 1
+	}
+	buf.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow ugc">` + html.EscapeString(link) + "</a>")
+	return j
+}
+
+// **strong**, __strong__, *em*, _em_ and ~~del~~; underscores inside words are text, like in snake_case
+func (in *inline) renderEmphasis(i int) int {
+	text := in.text
+	c := text[i]
+	delim := text[i : i+1]
+	if runLength(text, i, c) >= 2 {
+		delim = text[i : i+2]
+	}
+	if delim == "~" || (c == '_' && i > 0 && isAlnum(text[i-1])) ||
+		i+len(delim) >= len(text) || isSpace(text[i+len(delim)]) {
+		in.buf.WriteString(delim)
+		return i + len(delim)
+	}
+	j := in.closer(c, len(delim), i)
+	if j == len(text) {
+		in.buf.WriteString(delim)
+		return i + len(delim)
+	}
+	tag := "em"
+	if c == '~' {
+		tag = "del"
+	} else if len(delim) == 2 {
+		tag = "strong"
+	}
+	in.buf.WriteString("<" + tag + ">")
+	renderInline(in.buf, text[i+len(delim):j], in.depth+1, in.inLink)
+	in.buf.WriteString("</" + tag + ">")
+	return j + len(delim)
+}
+
+// first closing run of n characters c after i, or length of text if there is none;
+// openers come in order, so the cursor never has to go back
+func (in *inline) closer(c byte, n, i int) int {
+	cursor := &in.closers[strings.IndexByte("*_~", c)][n-1]
+	if *cursor > i {
+		return *cursor
+	}
+	*cursor = len(in.text)
+	for j := i + 1; j < len(in.text); j++ {
+		if in.closes(c, n, j) {
+			*cursor = j
+			break
+		}
+	}
+	return *cursor
+}
+
+// closing run has the same length as opening one, longer and shorter runs belong to nested markup
+func (in *inline) closes(c byte, n, j int) bool {
+	text := in.text
+	if text[j] != c || text[j-1] == c || isSpace(text[j-1]) || runLength(text, j, c) != n {
+		return false
+	}
+	after := j + n
+	return !(c == '_' && after < len(text) && isAlnum(text[after]))
+}
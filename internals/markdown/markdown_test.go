@@ -0,0 +1,118 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"paragraphs", "hello\nworld\n\nsecond", "<p>hello\nworld</p>\n<p>second</p>\n"},
+		{"line break", "line  \nbreak", "<p>line<br>\nbreak</p>\n"},
+		{"crlf", "a\r\n\r\nb", "<p>a</p>\n<p>b</p>\n"},
+		{"emphasis", "*em* **strong** _em_ __strong__ ~~del~~",
+			"<p><em>em</em> <strong>strong</strong> <em>em</em> <strong>strong</strong> <del>del</del></p>\n"},
+		{"nested emphasis", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"underscores in words", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"unclosed emphasis", "*a **b", "<p>*a **b</p>\n"},
+		{"single tilde", "~single~", "<p>~single~</p>\n"},
+		{"escapes", `\*not em\*`, "<p>*not em*</p>\n"},
+		{"code span", "`code <b>` and ``a ` b``", "<p><code>code &lt;b&gt;</code> and <code>a ` b</code></p>\n"},
+		{"unclosed code span", "``a`", "<p>``a`</p>\n"},
+		{"link", `[site](https://example.com "title")`, "<p><a href=\"https://example.com\" rel=\"nofollow ugc\">site</a></p>\n"},
+		{"site links", "[local](/a/news) [anchor](#top) [mail](mailto:a@b.c)",
+			"<p><a href=\"/a/news\" rel=\"nofollow ugc\">local</a> <a href=\"#top\" rel=\"nofollow ugc\">anchor</a> <a href=\"mailto:a@b.c\" rel=\"nofollow ugc\">mail</a></p>\n"},
+		{"markup in link", "[**b**](/x)", "<p><a href=\"/x\" rel=\"nofollow ugc\"><strong>b</strong></a></p>\n"},
+		{"link in link", "[a [b](/c)](/d)", "<p><a href=\"/d\" rel=\"nofollow ugc\">a [b](/c)</a></p>\n"},
+		{"not a link", "[a] (b) [c](", "<p>[a] (b) [c](</p>\n"},
+		{"autolink", "see https://example.com/a?b=1&c=2.",
+			"<p>see <a href=\"https://example.com/a?b=1&amp;c=2\" rel=\"nofollow ugc\">https://example.com/a?b=1&amp;c=2</a>.</p>\n"},
+		{"quote", "> quote\n> more", "<blockquote>\n<p>quote\nmore</p>\n</blockquote>\n"},
+		{"nested quote", "> a\n>> b", "<blockquote>\n<p>a</p>\n<blockquote>\n<p>b</p>\n</blockquote>\n</blockquote>\n"},
+		{"lists", "- one\n- two\n\n1. a\n2. b",
+			"<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n<ol>\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"ordered list start", "3. three\n4. four", "<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n"},
+		{"number in paragraph", "it was\n2021. a year", "<p>it was\n2021. a year</p>\n"},
+		{"nested list", "- a\n  - b", "<ul>\n<li>a\n<ul>\n<li>b</li>\n</ul>\n</li>\n</ul>\n"},
+		{"fenced code", "```go\nfmt.Println(\"<x>\")\n```",
+			"<pre><code class=\"language-go\">fmt.Println(&#34;&lt;x&gt;&#34;)\n</code></pre>\n"},
+		{"unclosed fence", "~~~\n*a*", "<pre><code>*a*\n</code></pre>\n"},
+		{"html", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.in); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderUnsafe(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"javascript link", "[x](javascript:alert(1))", "<p>x</p>\n"},
+		{"mixed case scheme", "[x](JaVaScRiPt:alert(1))", "<p>x</p>\n"},
+		{"control character in scheme", "[x](java\tscript:alert(1))", "<p>x</p>\n"},
+		{"data link", "[x](data:text/html,hi)", "<p>x</p>\n"},
+		{"protocol relative link", "[x](//evil.com)", "<p>x</p>\n"},
+		{"quote in link", `[x](http://a"onmouseover=alert(1))`,
+			"<p><a href=\"http://a&#34;onmouseover=alert(1)\" rel=\"nofollow ugc\">x</a></p>\n"},
+		{"double quote in autolink", `https://a.com/"onmouseover=alert(1)`,
+			"<p><a href=\"https://a.com/%22onmouseover=alert%281\" rel=\"nofollow ugc\">https://a.com/&#34;onmouseover=alert(1</a>)</p>\n"},
+		{"single quote in autolink", "https://a.com/'x'",
+			"<p><a href=\"https://a.com/&#39;x\" rel=\"nofollow ugc\">https://a.com/&#39;x</a>&#39;</p>\n"},
+		{"javascript autolink", "javascript:alert(1)", "<p>javascript:alert(1)</p>\n"},
+		{"quote in fence info", "```\" onclick=alert(1)\nx\n```", "<pre><code>x\n</code></pre>\n"},
+		{"tag in fence info", "```js\"><script>\nx\n```", "<pre><code>x\n</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.in); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// each of these took seconds when closing markup was looked for from every opener
+func TestRenderPathological(t *testing.T) {
+	const n = 20000
+	ticks := &strings.Builder{}
+	for i := 1; i < 300; i++ {
+		ticks.WriteString(strings.Repeat("`", i) + "a")
+	}
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"unclosed emphasis", strings.Repeat("*a ", n)},
+		{"unclosed underscores", strings.Repeat("_a ", n)},
+		{"unclosed strikethrough", strings.Repeat("~~a ", n)},
+		{"mixed emphasis", strings.Repeat("*a **b ", n)},
+		{"unclosed brackets", strings.Repeat("[", n)},
+		{"unclosed links", strings.Repeat("[a](", n)},
+		{"unclosed parens", "[a](" + strings.Repeat("(", n)},
+		{"backtick runs", ticks.String()},
+		{"nested quotes", strings.Repeat(">", n)},
+		{"nested lists", strings.Repeat("- ", n) + "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			Render(tt.in)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Render took %s for %d bytes", elapsed, len(tt.in))
+			}
+		})
+	}
+}
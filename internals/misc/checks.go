@@ -20,3 +20,13 @@ func IsValidUsername(str string) bool {
 	}
 	return true
 }
+
+// category names are part of urls, so only lowercase ascii letters, digits, '-' and '_' are allowed
+func IsValidCategoryName(str string) bool {
+	for _, c := range str {
+		if !(unicode.IsDigit(c) || c == '-' || c == '_' || (unicode.IsLower(c) && c < unicode.MaxASCII)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package misc
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrBadURL    = errors.New("is invalid")
+	ErrBadScheme = errors.New("scheme must be http or https")
+)
+
+// query params which only track where the link came from
+var trackingParams = map[string]struct{}{
+	"fbclid":  {},
+	"gclid":   {},
+	"yclid":   {},
+	"dclid":   {},
+	"mc_cid":  {},
+	"mc_eid":  {},
+	"igshid":  {},
+	"ref_src": {},
+}
+
+// NormalizeURL returns canonical form of http(s) url: lowercase scheme and host, no default port,
+// no credentials, no fragment, no tracking params and sorted query
+func NormalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", ErrBadURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", ErrBadScheme
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", ErrBadURL
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // ipv6
+	}
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	u.Host = host
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	query := u.Query()
+	for key := range query {
+		lowKey := strings.ToLower(key)
+		if _, ok := trackingParams[lowKey]; ok || strings.HasPrefix(lowKey, "utm_") {
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode() // sorted by key
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// HostInDomains checks if host is one of domains or their subdomain
+func HostInDomains(host string, domains []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// DomainPolicy denies links to Deny domains and, if Allow is not empty, to any domain not in Allow
+type DomainPolicy struct {
+	Allow []string `json:"allow" bson:"allow"`
+	Deny  []string `json:"deny"  bson:"deny"`
+}
+
+func (dp DomainPolicy) Allows(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	if HostInDomains(u.Hostname(), dp.Deny) {
+		return false
+	}
+	return len(dp.Allow) == 0 || HostInDomains(u.Hostname(), dp.Allow)
+}
+
+// ParseDomains splits comma separated list of domains, empty string gives empty list
+func ParseDomains(str string) []string {
+	domains := make([]string, 0)
+	for _, domain := range strings.Split(str, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, strings.ToLower(domain))
+		}
+	}
+	return domains
+}
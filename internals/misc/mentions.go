@@ -0,0 +1,23 @@
+package misc
+
+import "regexp"
+
+// @username not preceded by a username character, so emails are not mentions
+var mentionRe = regexp.MustCompile(`(?:^|[^A-Za-z0-9_-])@([A-Za-z0-9_-]{1,32})`)
+
+// returns up to limit distinct usernames mentioned in text, in order of appearance
+func Mentions(text string, limit int) []string {
+	var usernames []string
+	seen := make(map[string]struct{})
+	for _, match := range mentionRe.FindAllStringSubmatch(text, -1) {
+		if len(usernames) == limit {
+			break
+		}
+		if _, ok := seen[match[1]]; ok {
+			continue
+		}
+		seen[match[1]] = struct{}{}
+		usernames = append(usernames, match[1])
+	}
+	return usernames
+}
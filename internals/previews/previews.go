@@ -0,0 +1,257 @@
+// Package previews fetches pages behind link posts and extracts what they say about themselves
+// with opengraph meta tags. Every connection is checked after name resolution, so neither
+// a link nor its redirects can reach loopback, private or other non-public addresses.
+package previews
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"reddit_clone/internals/storage"
+)
+
+var (
+	ErrForbiddenAddress = errors.New("address is not public")
+	ErrForbiddenPort    = errors.New("only ports 80 and 443 are allowed")
+	ErrNoPreview        = errors.New("page has nothing to preview")
+)
+
+const (
+	maxRedirects      = 5
+	maxTitleLen       = 300
+	maxDescriptionLen = 1000
+	maxSiteNameLen    = 100
+)
+
+type Fetcher struct {
+	Client   *http.Client
+	MaxBytes int64 // rest of the page is not read, meta tags are expected in the head
+
+	slots chan struct{} // limits fetches running at once
+}
+
+func NewFetcher(timeout time.Duration, maxBytes int64, workers int) *Fetcher {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkAddress}
+	transport := &http.Transport{
+		Proxy:                  nil, // proxy would dial instead of us and skip the address check
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    timeout,
+		ResponseHeaderTimeout:  timeout,
+		MaxResponseHeaderBytes: 64 << 10,
+		DisableKeepAlives:      true,
+	}
+	return &Fetcher{
+		Client: &http.Client{
+			Transport:     transport,
+			Timeout:       timeout,
+			CheckRedirect: checkRedirect,
+		},
+		MaxBytes: maxBytes,
+		slots:    make(chan struct{}, workers),
+	}
+}
+
+func (f *Fetcher) Fetch(rawURL string, ctx context.Context) (*storage.Preview, error) {
+	link, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	} else if link.Scheme != "http" && link.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", link.Scheme)
+	}
+
+	select {
+	case f.slots <- struct{}{}:
+		defer func() { <-f.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", link.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("user-agent", "reddit_clone-preview/1.0")
+	req.Header.Set("accept", "text/html,application/xhtml+xml")
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("content-type")); err != nil {
+		return nil, err
+	} else if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNoPreview
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	preview := Extract(string(page), resp.Request.URL)
+	if preview.Title == "" && preview.Description == "" && preview.Image == "" {
+		return nil, ErrNoPreview
+	}
+	preview.Fetched = time.Now()
+	return &preview, nil
+}
+
+//----------------------------------------Addresses----------------------------------------//
+
+// called with resolved address right before connecting, so dns cannot point us somewhere else later
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if port != "80" && port != "443" {
+		return ErrForbiddenPort
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errors.New("too many redirects")
+	} else if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+	}
+	return nil
+}
+
+// ranges not covered by net.IP methods: shared, benchmarking, reserved and nat64,
+// which can be used to reach ipv4 addresses through ipv6
+var nonPublic = parseNets(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"2001:db8::/32",
+)
+
+func parseNets(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func IsPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, ipNet := range nonPublic {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+//----------------------------------------Extraction----------------------------------------//
+
+var (
+	metaRe  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrRe  = regexp.MustCompile(`(?is)([a-z_:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// Extract reads opengraph tags from the page, falling back to twitter cards and plain title and description;
+// links are resolved against base, the url the page was finally fetched from
+func Extract(page string, base *url.URL) storage.Preview {
+	if end := strings.Index(strings.ToLower(page), "</head>"); end != -1 {
+		page = page[:end]
+	}
+	page = strings.ToValidUTF8(page, "")
+
+	meta := map[string]string{}
+	for _, tag := range metaRe.FindAllString(page, -1) {
+		attrs := map[string]string{}
+		for _, match := range attrRe.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(match[1])] = match[2] + match[3] + match[4]
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, ok := meta[key]; !ok && key != "" {
+			meta[key] = clean(attrs["content"])
+		}
+	}
+	if match := titleRe.FindStringSubmatch(page); match != nil {
+		meta["title"] = clean(match[1])
+	}
+
+	preview := storage.Preview{
+		URL:         base.String(),
+		Title:       truncate(first(meta, "og:title", "twitter:title", "title"), maxTitleLen),
+		Description: truncate(first(meta, "og:description", "twitter:description", "description"), maxDescriptionLen),
+		SiteName:    truncate(first(meta, "og:site_name"), maxSiteNameLen),
+		Image:       resolve(base, first(meta, "og:image:secure_url", "og:image", "og:image:url", "twitter:image")),
+	}
+	if canonical := resolve(base, first(meta, "og:url")); canonical != "" {
+		preview.URL = canonical
+	}
+	return preview
+}
+
+func first(meta map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := meta[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func clean(value string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(value)), " ")
+}
+
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
+
+// only absolute http(s) links are kept, anything else could be a script for the frontend
+func resolve(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	link, err := base.Parse(ref)
+	if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+		return ""
+	}
+	return link.String()
+}
@@ -0,0 +1,225 @@
+package previews
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"reddit_clone/internals/storage"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b::7f00:1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := IsPublic(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("IsPublic(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    error
+	}{
+		{"93.184.216.34:443", nil},
+		{"93.184.216.34:80", nil},
+		{"[2606:4700:4700::1111]:443", nil},
+		{"93.184.216.34:8080", ErrForbiddenPort},
+		{"127.0.0.1:80", ErrForbiddenAddress},
+		{"[::ffff:10.0.0.1]:443", ErrForbiddenAddress},
+		{"169.254.169.254:80", ErrForbiddenAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if err := checkAddress("tcp", tt.address, nil); err != tt.want {
+				t.Errorf("checkAddress(%s) = %v, want %v", tt.address, err, tt.want)
+			}
+		})
+	}
+}
+
+// test server listens on loopback, so its address alone is let through; every other
+// connection, including the ones redirects lead to, is checked as usual
+func newTestFetcher(server *httptest.Server) *Fetcher {
+	f := NewFetcher(5*time.Second, 1<<20, 1)
+	allowed := server.Listener.Addr().String()
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: func(network, address string, c syscall.RawConn) error {
+		if address == allowed {
+			return nil
+		}
+		return checkAddress(network, address, c)
+	}}
+	f.Client.Transport.(*http.Transport).DialContext = dialer.DialContext
+	return f
+}
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><meta property="og:title" content="Title"><meta property="og:image" content="/a.png"></head></html>`)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/loopback", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost/page", http.StatusFound)
+	})
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://10.0.0.1/page", http.StatusFound)
+	})
+	mux.HandleFunc("/metadata", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	})
+	mux.HandleFunc("/scheme", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	f := newTestFetcher(server)
+	ctx := context.Background()
+
+	for _, path := range []string{"/page", "/moved"} {
+		preview, err := f.Fetch(server.URL+path, ctx)
+		if err != nil {
+			t.Fatalf("Fetch(%s) = %v", path, err)
+		}
+		if preview.Title != "Title" || preview.Image != server.URL+"/a.png" || preview.URL != server.URL+"/page" {
+			t.Errorf("Fetch(%s) = %+v", path, preview)
+		}
+	}
+
+	tests := []struct {
+		path string
+		want error
+	}{
+		{"/loopback", ErrForbiddenAddress},
+		{"/private", ErrForbiddenAddress},
+		{"/metadata", ErrForbiddenAddress},
+		{"/image", ErrNoPreview},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if _, err := f.Fetch(server.URL+tt.path, ctx); !errors.Is(err, tt.want) {
+				t.Errorf("Fetch(%s) = %v, want %v", tt.path, err, tt.want)
+			}
+		})
+	}
+	for _, path := range []string{"/scheme", "/loop"} {
+		if _, err := f.Fetch(server.URL+path, ctx); err == nil {
+			t.Errorf("Fetch(%s) = nil, want error", path)
+		}
+	}
+}
+
+func TestFetchChecksAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("server on loopback was reached")
+	}))
+	defer server.Close()
+	f := NewFetcher(5*time.Second, 1<<20, 1)
+	if _, err := f.Fetch(server.URL, context.Background()); !errors.Is(err, ErrForbiddenPort) {
+		t.Errorf("Fetch() = %v, want %v", err, ErrForbiddenPort)
+	}
+	if _, err := f.Fetch("http://127.0.0.1/", context.Background()); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Fetch() = %v, want %v", err, ErrForbiddenAddress)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	base, _ := url.Parse("https://example.com/articles/1")
+	tests := []struct {
+		name string
+		page string
+		want storage.Preview
+	}{
+		{
+			"opengraph",
+			`<head><meta property="og:title" content="OG title"><meta property="og:description" content="About">
+			<meta property="og:site_name" content="Example"><meta property="og:image" content="https://cdn.example.com/a.png">
+			<meta property="og:url" content="https://example.com/articles/1?ref=og"><title>Plain</title></head>`,
+			storage.Preview{URL: "https://example.com/articles/1?ref=og", Title: "OG title", Description: "About",
+				SiteName: "Example", Image: "https://cdn.example.com/a.png"},
+		},
+		{
+			"twitter card",
+			`<meta name="twitter:title" content="Card"><meta name="twitter:description" content="Card text">
+			<meta name="twitter:image" content="/card.png">`,
+			storage.Preview{URL: "https://example.com/articles/1", Title: "Card", Description: "Card text",
+				Image: "https://example.com/card.png"},
+		},
+		{
+			"plain title and description",
+			`<title> Plain
+			title </title><meta name="description" content="Plain &amp; simple">`,
+			storage.Preview{URL: "https://example.com/articles/1", Title: "Plain title", Description: "Plain & simple"},
+		},
+		{
+			"attribute order and quotes",
+			`<META content='Single' PROPERTY=og:title><meta content=bare property="og:description">`,
+			storage.Preview{URL: "https://example.com/articles/1", Title: "Single", Description: "bare"},
+		},
+		{
+			"first tag wins",
+			`<meta property="og:title" content="First"><meta property="og:title" content="Second">`,
+			storage.Preview{URL: "https://example.com/articles/1", Title: "First"},
+		},
+		{
+			"unsafe links dropped",
+			`<meta property="og:title" content="x"><meta property="og:image" content="javascript:alert(1)">
+			<meta property="og:url" content="data:text/html,hi">`,
+			storage.Preview{URL: "https://example.com/articles/1", Title: "x"},
+		},
+		{
+			"body ignored",
+			`<head></head><body><meta property="og:title" content="In body"></body>`,
+			storage.Preview{URL: "https://example.com/articles/1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Extract(tt.page, base); got != tt.want {
+				t.Errorf("Extract() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
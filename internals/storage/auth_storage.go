@@ -110,6 +110,21 @@ func (as *AuthStorageImpl) CheckCredentials(username, password string) (int, err
 	return StatusLoginOK, nil
 }
 
+func (as *AuthStorageImpl) IsAdmin(userID string) (bool, error) {
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
+		return false, err
+	}
+	row := as.users.QueryRow("SELECT is_admin FROM users WHERE user_id = $1;", rawID)
+	var res bool
+	if err := row.Scan(&res); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return res, nil
+}
+
 type SessionJWTClaims struct {
 	User `json:"user"`
 	jwt.StandardClaims
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// Bans are stored in postgres near users, see users_init.sql
+type BanStorageImpl struct {
+	users *sql.DB
+}
+
+func NewBanStorage(users *sql.DB) BanStorage {
+	return &BanStorageImpl{
+		users: users,
+	}
+}
+
+// nil until is stored as null, which means permanent ban
+func nullUntil(until *time.Time) sql.NullTime {
+	if until == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *until, Valid: true}
+}
+
+func scanBan(row *sql.Row) (*Ban, error) {
+	ban := &Ban{}
+	var byID []byte
+	var until sql.NullTime
+	if err := row.Scan(&ban.Reason, &byID, &ban.By.Username, &ban.Created, &until); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ban.By.ID = hex.EncodeToString(byID)
+	if until.Valid {
+		ban.Until = &until.Time
+	}
+	return ban, nil
+}
+
+func (bs *BanStorageImpl) Suspend(userID string, ban Ban, ctx context.Context) error {
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
+		return err
+	}
+	byID, err := hex.DecodeString(ban.By.ID)
+	if err != nil {
+		return err
+	}
+	_, err = bs.users.ExecContext(ctx, `INSERT INTO suspensions (user_id, reason, by_id, by_name, created, until)
+		VALUES ($1,$2,$3,$4,$5,$6)
+		ON CONFLICT (user_id) DO UPDATE
+		SET reason = $2, by_id = $3, by_name = $4, created = $5, until = $6;`,
+		rawID, ban.Reason, byID, ban.By.Username, ban.Created, nullUntil(ban.Until))
+	return err
+}
+
+func (bs *BanStorageImpl) Unsuspend(userID string, ctx context.Context) error {
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
+		return err
+	}
+	_, err = bs.users.ExecContext(ctx, "DELETE FROM suspensions WHERE user_id = $1;", rawID)
+	return err
+}
+
+func (bs *BanStorageImpl) GetSuspension(userID string, ctx context.Context) (*Ban, error) {
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
+		return nil, err
+	}
+	row := bs.users.QueryRowContext(ctx, `SELECT reason, by_id, by_name, created, until FROM suspensions
+		WHERE user_id = $1 AND (until IS NULL OR until > now());`, rawID)
+	return scanBan(row)
+}
+
+func (bs *BanStorageImpl) ShadowBan(userID string, shadowBanned bool, ctx context.Context) error {
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
+		return err
+	}
+	_, err = bs.users.ExecContext(ctx, "UPDATE users SET shadow_banned = $1 WHERE user_id = $2;", shadowBanned, rawID)
+	return err
+}
+
+// content of shadow banned users is seen only by them, so it must not reach anybody else
+func (bs *BanStorageImpl) IsShadowBanned(userID string, ctx context.Context) (bool, error) {
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
+		return false, err
+	}
+	var shadowBanned bool
+	err = bs.users.QueryRowContext(ctx, "SELECT shadow_banned FROM users WHERE user_id = $1;", rawID).Scan(&shadowBanned)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return shadowBanned, err
+}
+
+// ids of all shadow banned users
+func (bs *BanStorageImpl) GetShadowBanned(ctx context.Context) ([]string, error) {
+	rows, err := bs.users.QueryContext(ctx, "SELECT user_id FROM users WHERE shadow_banned;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var rawID []byte
+		if err := rows.Scan(&rawID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, hex.EncodeToString(rawID))
+	}
+	return ids, rows.Err()
+}
+
+func (bs *BanStorageImpl) BanFromCategory(category, userID string, ban Ban, ctx context.Context) error {
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
+		return err
+	}
+	byID, err := hex.DecodeString(ban.By.ID)
+	if err != nil {
+		return err
+	}
+	_, err = bs.users.ExecContext(ctx, `INSERT INTO category_bans (category, user_id, reason, by_id, by_name, created, until)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		ON CONFLICT (category, user_id) DO UPDATE
+		SET reason = $3, by_id = $4, by_name = $5, created = $6, until = $7;`,
+		category, rawID, ban.Reason, byID, ban.By.Username, ban.Created, nullUntil(ban.Until))
+	return err
+}
+
+func (bs *BanStorageImpl) UnbanFromCategory(category, userID string, ctx context.Context) error {
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
+		return err
+	}
+	_, err = bs.users.ExecContext(ctx, "DELETE FROM category_bans WHERE category = $1 AND user_id = $2;", category, rawID)
+	return err
+}
+
+func (bs *BanStorageImpl) GetCategoryBan(category, userID string, ctx context.Context) (*Ban, error) {
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
+		return nil, err
+	}
+	row := bs.users.QueryRowContext(ctx, `SELECT reason, by_id, by_name, created, until FROM category_bans
+		WHERE category = $1 AND user_id = $2 AND (until IS NULL OR until > now());`, category, rawID)
+	return scanBan(row)
+}
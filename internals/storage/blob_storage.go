@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Blobs are files in one directory, content type is not kept because it is known from the key extension
+type LocalBlobStoreImpl struct {
+	dir string
+}
+
+func NewLocalBlobStore(dir string) BlobStore {
+	return &LocalBlobStoreImpl{
+		dir: dir,
+	}
+}
+
+func (ls *LocalBlobStoreImpl) Put(key, contentType string, data []byte, ctx context.Context) error {
+	path, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ls.dir, 0755); err != nil {
+		return err
+	}
+	// readers never see half written file
+	tmp, err := os.CreateTemp(ls.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (ls *LocalBlobStoreImpl) Get(key string, ctx context.Context) (io.ReadCloser, error) {
+	path, err := ls.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return file, err
+}
+
+func (ls *LocalBlobStoreImpl) Delete(key string, ctx context.Context) error {
+	path, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (ls *LocalBlobStoreImpl) path(key string) (string, error) {
+	if key == "" || key[0] == '.' || filepath.Base(key) != key {
+		return "", errors.New("bad blob key")
+	}
+	return filepath.Join(ls.dir, key), nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"reddit_clone/internals/automod"
+	"reddit_clone/internals/misc"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Category name is used as document id, so names are unique
+// Subscriptions are kept as separate documents with user_id and category
+type CategoryStorageImpl struct {
+	categories    *mongo.Collection
+	subscriptions *mongo.Collection
+}
+
+func NewCategoryStorage(categories, subscriptions *mongo.Collection) CategoryStorage {
+	return &CategoryStorageImpl{
+		categories:    categories,
+		subscriptions: subscriptions,
+	}
+}
+
+func (cs *CategoryStorageImpl) GetCategory(name string, ctx context.Context) (*Category, error) {
+	category := &Category{}
+	err := cs.categories.FindOne(ctx, bson.M{"_id": name}).Decode(category)
+	return category, err
+}
+
+func (cs *CategoryStorageImpl) GetCategories(ctx context.Context) ([]*Category, error) {
+	cursor, err := cs.categories.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	categories := make([]*Category, 0, 10)
+	for cursor.Next(ctx) {
+		category := &Category{}
+		if err := cursor.Decode(category); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, cursor.Err()
+}
+
+func (cs *CategoryStorageImpl) CheckCategoryExist(name string, ctx context.Context) (bool, error) {
+	if err := cs.categories.FindOne(ctx, bson.M{"_id": name}).Err(); err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (cs *CategoryStorageImpl) CreateCategory(newCategory *NewCategory, user User, ctx context.Context) error {
+	rules := newCategory.Rules
+	if rules == nil {
+		rules = []string{}
+	}
+	category := &Category{
+		Name:        newCategory.Name,
+		Description: newCategory.Description,
+		Rules:       rules,
+		Moderators:  []Author{},
+		Owner: Author{
+			Username: user.Username,
+			ID:       user.UserID,
+		},
+		Created: time.Now(),
+	}
+	_, err := cs.categories.InsertOne(ctx, category)
+	return err
+}
+
+func (cs *CategoryStorageImpl) Subscribe(category string, user User, ctx context.Context) error {
+	subscription := bson.M{"user_id": user.UserID, "category": category}
+	_, err := cs.subscriptions.UpdateOne(ctx, subscription, bson.M{"$set": subscription}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (cs *CategoryStorageImpl) Unsubscribe(category string, user User, ctx context.Context) error {
+	_, err := cs.subscriptions.DeleteOne(ctx, bson.M{"user_id": user.UserID, "category": category})
+	return err
+}
+
+func (cs *CategoryStorageImpl) GetSubscriptions(user User, ctx context.Context) ([]string, error) {
+	opts := options.Find().SetSort(bson.M{"category": 1})
+	cursor, err := cs.subscriptions.Find(ctx, bson.M{"user_id": user.UserID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	categories := make([]string, 0, 10)
+	for cursor.Next(ctx) {
+		subscription := struct {
+			Category string `bson:"category"`
+		}{}
+		if err := cursor.Decode(&subscription); err != nil {
+			return nil, err
+		}
+		categories = append(categories, subscription.Category)
+	}
+	return categories, cursor.Err()
+}
+
+func (cs *CategoryStorageImpl) AddModerator(category string, moderator Author, ctx context.Context) error {
+	_, err := cs.categories.UpdateByID(ctx, category, bson.M{"$addToSet": bson.M{"moderators": moderator}})
+	return err
+}
+
+func (cs *CategoryStorageImpl) RemoveModerator(category string, moderator Author, ctx context.Context) error {
+	_, err := cs.categories.UpdateByID(ctx, category, bson.M{"$pull": bson.M{"moderators": bson.M{"_id": moderator.ID}}})
+	return err
+}
+
+func (cs *CategoryStorageImpl) IsModerator(category string, user User, ctx context.Context) (bool, error) {
+	filter := bson.M{
+		"_id": category,
+		"$or": bson.A{
+			bson.M{"owner._id": user.UserID},
+			bson.M{"moderators._id": user.UserID},
+		},
+	}
+	if err := cs.categories.FindOne(ctx, filter).Err(); err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// names of categories user owns or moderates
+func (cs *CategoryStorageImpl) GetModerated(user User, ctx context.Context) ([]string, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"owner._id": user.UserID},
+		bson.M{"moderators._id": user.UserID},
+	}}
+	cursor, err := cs.categories.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	categories := make([]string, 0, 10)
+	for cursor.Next(ctx) {
+		category := struct {
+			Name string `bson:"_id"`
+		}{}
+		if err := cursor.Decode(&category); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category.Name)
+	}
+	return categories, cursor.Err()
+}
+
+func (cs *CategoryStorageImpl) SetAutomod(category string, rules []automod.Rule, ctx context.Context) error {
+	_, err := cs.categories.UpdateByID(ctx, category, bson.M{"$set": bson.M{"automod": rules}})
+	return err
+}
+
+func (cs *CategoryStorageImpl) SetDomains(category string, domains misc.DomainPolicy, ctx context.Context) error {
+	_, err := cs.categories.UpdateByID(ctx, category, bson.M{"$set": bson.M{"domains": domains}})
+	return err
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Hidden posts and blocks are kept as separate documents with user_id, like subscriptions
+type HideStorageImpl struct {
+	hidden *mongo.Collection
+	blocks *mongo.Collection
+}
+
+func NewHideStorage(hidden, blocks *mongo.Collection) HideStorage {
+	return &HideStorageImpl{
+		hidden: hidden,
+		blocks: blocks,
+	}
+}
+
+func (hs *HideStorageImpl) HidePost(postID string, user User, ctx context.Context) error {
+	filter := bson.M{"user_id": user.UserID, "post_id": postID}
+	update := bson.M{"$setOnInsert": bson.M{"created": time.Now()}}
+	_, err := hs.hidden.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (hs *HideStorageImpl) UnhidePost(postID string, user User, ctx context.Context) error {
+	_, err := hs.hidden.DeleteOne(ctx, bson.M{"user_id": user.UserID, "post_id": postID})
+	return err
+}
+
+// newest hidden first
+func (hs *HideStorageImpl) GetHiddenPosts(userID string, ctx context.Context) ([]string, error) {
+	opts := options.Find().SetSort(bson.M{"created": -1})
+	cursor, err := hs.hidden.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	posts := make([]string, 0, 10)
+	for cursor.Next(ctx) {
+		hidden := struct {
+			PostID string `bson:"post_id"`
+		}{}
+		if err := cursor.Decode(&hidden); err != nil {
+			return nil, err
+		}
+		posts = append(posts, hidden.PostID)
+	}
+	return posts, cursor.Err()
+}
+
+func (hs *HideStorageImpl) Block(blocked Author, user User, ctx context.Context) error {
+	filter := bson.M{"user_id": user.UserID, "blocked._id": blocked.ID}
+	update := bson.M{
+		"$set":         bson.M{"blocked": blocked},
+		"$setOnInsert": bson.M{"created": time.Now()},
+	}
+	_, err := hs.blocks.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (hs *HideStorageImpl) Unblock(blockedID string, user User, ctx context.Context) error {
+	_, err := hs.blocks.DeleteOne(ctx, bson.M{"user_id": user.UserID, "blocked._id": blockedID})
+	return err
+}
+
+func (hs *HideStorageImpl) GetBlocked(userID string, ctx context.Context) ([]Author, error) {
+	opts := options.Find().SetSort(bson.M{"blocked.username": 1})
+	cursor, err := hs.blocks.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	blocked := make([]Author, 0, 10)
+	for cursor.Next(ctx) {
+		block := struct {
+			Blocked Author `bson:"blocked"`
+		}{}
+		if err := cursor.Decode(&block); err != nil {
+			return nil, err
+		}
+		blocked = append(blocked, block.Blocked)
+	}
+	return blocked, cursor.Err()
+}
+
+func (hs *HideStorageImpl) IsBlocked(userID, byID string, ctx context.Context) (bool, error) {
+	count, err := hs.blocks.CountDocuments(ctx, bson.M{"user_id": byID, "blocked._id": userID})
+	return count > 0, err
+}
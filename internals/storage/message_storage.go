@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Direct messages are kept in their own collection, "messages" one holds posts
+type MessageStorageImpl struct {
+	messages *mongo.Collection
+}
+
+func NewMessageStorage(messages *mongo.Collection) MessageStorage {
+	return &MessageStorageImpl{
+		messages: messages,
+	}
+}
+
+// conversation id does not depend on who wrote first
+func ConversationID(userID, otherID string) string {
+	if userID > otherID {
+		userID, otherID = otherID, userID
+	}
+	return userID + "-" + otherID
+}
+
+func (ms *MessageStorageImpl) EnsureIndexes(ctx context.Context) error {
+	_, err := ms.messages.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "to._id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "from._id", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return err
+}
+
+func (ms *MessageStorageImpl) SendMessage(message *DirectMessage, ctx context.Context) error {
+	message.ConversationID = ConversationID(message.From.ID, message.To.ID)
+	res, err := ms.messages.InsertOne(ctx, message)
+	if err != nil {
+		return err
+	}
+	message.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// messages visible to the user: shadow messages are seen only by their sender,
+// messages from blocked users are hidden, also the ones sent before they were blocked
+func visibleTo(userID string, blocked []string, filter bson.M) bson.M {
+	hidden := bson.A{bson.M{"shadow": true, "to._id": userID}}
+	if len(blocked) > 0 {
+		hidden = append(hidden, bson.M{"from._id": bson.M{"$in": blocked}})
+	}
+	filter["$nor"] = hidden
+	return filter
+}
+
+func (ms *MessageStorageImpl) findMessages(filter bson.M, opts ListOptions, ctx context.Context) ([]*DirectMessage, error) {
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64(opts.Offset)).
+		SetLimit(int64(opts.Limit))
+	cursor, err := ms.messages.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	messages := make([]*DirectMessage, 0, opts.Limit)
+	for cursor.Next(ctx) {
+		message := &DirectMessage{}
+		if err := cursor.Decode(message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, cursor.Err()
+}
+
+func (ms *MessageStorageImpl) GetInbox(userID string, blocked []string, opts ListOptions, ctx context.Context) ([]*DirectMessage, error) {
+	return ms.findMessages(visibleTo(userID, blocked, bson.M{"to._id": userID}), opts, ctx)
+}
+
+func (ms *MessageStorageImpl) GetSent(userID string, opts ListOptions, ctx context.Context) ([]*DirectMessage, error) {
+	return ms.findMessages(bson.M{"from._id": userID}, opts, ctx)
+}
+
+func (ms *MessageStorageImpl) GetConversation(userID, otherID string, opts ListOptions, ctx context.Context) ([]*DirectMessage, error) {
+	filter := visibleTo(userID, nil, bson.M{"conversation_id": ConversationID(userID, otherID)})
+	return ms.findMessages(filter, opts, ctx)
+}
+
+// conversations with the latest message in each, most recently active first
+func (ms *MessageStorageImpl) GetConversations(userID string, blocked []string, opts ListOptions, ctx context.Context) ([]*Conversation, error) {
+	filter := visibleTo(userID, blocked, bson.M{"$or": bson.A{bson.M{"from._id": userID}, bson.M{"to._id": userID}}})
+	unread := bson.M{"$cond": bson.A{
+		bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$to._id", userID}},
+			bson.M{"$not": bson.A{"$read_at"}},
+		}},
+		1, 0,
+	}}
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$sort": bson.M{"_id": -1}},
+		bson.M{"$group": bson.M{
+			"_id":    "$conversation_id",
+			"last":   bson.M{"$first": "$$ROOT"},
+			"unread": bson.M{"$sum": unread},
+		}},
+		bson.M{"$sort": bson.M{"last._id": -1}},
+		bson.M{"$skip": opts.Offset},
+		bson.M{"$limit": opts.Limit},
+	}
+	cursor, err := ms.messages.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	conversations := make([]*Conversation, 0, opts.Limit)
+	for cursor.Next(ctx) {
+		conversation := &Conversation{}
+		if err := cursor.Decode(conversation); err != nil {
+			return nil, err
+		}
+		conversation.With = conversation.Last.To
+		if conversation.With.ID == userID {
+			conversation.With = conversation.Last.From
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, cursor.Err()
+}
+
+// marks all messages from the other user as read now
+func (ms *MessageStorageImpl) MarkRead(userID, otherID string, readAt time.Time, ctx context.Context) error {
+	filter := visibleTo(userID, nil, bson.M{"to._id": userID, "from._id": otherID, "read_at": bson.M{"$exists": false}})
+	_, err := ms.messages.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"read_at": readAt}})
+	return err
+}
+
+func (ms *MessageStorageImpl) CountUnread(userID string, blocked []string, ctx context.Context) (int, error) {
+	filter := visibleTo(userID, blocked, bson.M{"to._id": userID, "read_at": bson.M{"$exists": false}})
+	count, err := ms.messages.CountDocuments(ctx, filter)
+	return int(count), err
+}
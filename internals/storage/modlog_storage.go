@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ModLogStorageImpl struct {
+	entries *mongo.Collection
+}
+
+func NewModLogStorage(entries *mongo.Collection) ModLogStorage {
+	return &ModLogStorageImpl{
+		entries: entries,
+	}
+}
+
+func (ms *ModLogStorageImpl) AddEntry(entry *ModLogEntry, ctx context.Context) error {
+	_, err := ms.entries.InsertOne(ctx, entry)
+	return err
+}
+
+// entries are written before their action, only an entry of the action which failed is removed
+func (ms *ModLogStorageImpl) RemoveEntry(entryID primitive.ObjectID, ctx context.Context) error {
+	_, err := ms.entries.DeleteOne(ctx, bson.M{"_id": entryID})
+	return err
+}
+
+// newest entries go first
+func (ms *ModLogStorageImpl) GetEntries(filter ModLogFilter, ctx context.Context) ([]*ModLogEntry, error) {
+	query := bson.M{"category": filter.Category}
+	if filter.Moderator != "" {
+		query["moderator.username"] = filter.Moderator
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	created := bson.M{}
+	if !filter.Since.IsZero() {
+		created["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		created["$lt"] = filter.Until
+	}
+	if len(created) > 0 {
+		query["created"] = created
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(filter.Offset)).
+		SetLimit(int64(filter.Limit))
+	cursor, err := ms.entries.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	entries := make([]*ModLogEntry, 0, 10)
+	for cursor.Next(ctx) {
+		entry := &ModLogEntry{}
+		if err := cursor.Decode(entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, cursor.Err()
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type NotificationStorageImpl struct {
+	notifications *mongo.Collection
+	posts         *mongo.Collection
+}
+
+func NewNotificationStorage(notifications, posts *mongo.Collection) NotificationStorage {
+	return &NotificationStorageImpl{
+		notifications: notifications,
+		posts:         posts,
+	}
+}
+
+func (ns *NotificationStorageImpl) AddNotifications(notifications []*Notification, ctx context.Context) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, 0, len(notifications))
+	for _, notification := range notifications {
+		docs = append(docs, notification)
+	}
+	_, err := ns.notifications.InsertMany(ctx, docs)
+	return err
+}
+
+func notificationsOf(userID string, blocked []string) bson.M {
+	filter := bson.M{"user_id": userID}
+	if len(blocked) > 0 {
+		filter["from._id"] = bson.M{"$nin": blocked}
+	}
+	return filter
+}
+
+// newest first
+func (ns *NotificationStorageImpl) GetNotifications(userID string, blocked []string, unreadOnly bool, opts ListOptions, ctx context.Context) ([]*Notification, error) {
+	filter := notificationsOf(userID, blocked)
+	if unreadOnly {
+		filter["read"] = false
+	}
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64(opts.Offset)).
+		SetLimit(int64(opts.Limit))
+	cursor, err := ns.notifications.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	notifications := make([]*Notification, 0, opts.Limit)
+	for cursor.Next(ctx) {
+		notification := &Notification{}
+		if err := cursor.Decode(notification); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, ns.fillContent(notifications, ctx)
+}
+
+// comment body and post title are not copied into notifications but loaded when they are read,
+// so content of deleted and removed comments and posts is not shown anymore; the notification
+// itself stays listed, and counted while unread, with "[deleted]" in place of title and body
+func (ns *NotificationStorageImpl) fillContent(notifications []*Notification, ctx context.Context) error {
+	ids := make([]primitive.ObjectID, 0, len(notifications))
+	for _, notification := range notifications {
+		if id, err := primitive.ObjectIDFromHex(notification.PostID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	projection := bson.M{"title": 1, "deleted": 1, "comments._id": 1, "comments.body": 1, "comments.deleted": 1}
+	cursor, err := ns.posts.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(projection))
+	if err != nil {
+		return err
+	}
+	posts := []*Post{}
+	if err := cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+	byID := make(map[string]*Post, len(posts))
+	for _, post := range posts {
+		byID[hex.EncodeToString(post.ID)] = post
+	}
+	for _, notification := range notifications {
+		notification.PostTitle, notification.Body = "[deleted]", "[deleted]"
+		post, ok := byID[notification.PostID]
+		if !ok || post.Deleted != nil {
+			continue
+		}
+		notification.PostTitle = post.Title
+		for _, comment := range post.Comments {
+			if hex.EncodeToString(comment.ID) == notification.CommentID && comment.Deleted == nil {
+				notification.Body = comment.Body
+			}
+		}
+	}
+	return nil
+}
+
+func (ns *NotificationStorageImpl) CountUnread(userID string, blocked []string, ctx context.Context) (int, error) {
+	filter := notificationsOf(userID, blocked)
+	filter["read"] = false
+	count, err := ns.notifications.CountDocuments(ctx, filter)
+	return int(count), err
+}
+
+func (ns *NotificationStorageImpl) MarkRead(userID string, ids []string, ctx context.Context) error {
+	filter := bson.M{"user_id": userID, "read": false}
+	if len(ids) > 0 {
+		objectIDs := make([]primitive.ObjectID, 0, len(ids))
+		for _, id := range ids {
+			objectID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return err
+			}
+			objectIDs = append(objectIDs, objectID)
+		}
+		filter["_id"] = bson.M{"$in": objectIDs}
+	}
+	_, err := ns.notifications.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"read": true}})
+	return err
+}
@@ -7,11 +7,16 @@ import (
 	"encoding/hex"
 	"errors"
 	"log"
+	"strconv"
 	"time"
 
+	"reddit_clone/internals/markdown"
+
+	"github.com/lib/pq"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -19,60 +24,496 @@ const (
 	StatusError
 	StatusCommentNotExist
 	StatusPostNotExist
+	StatusRestoreOK
+	StatusNotDeleted
+	StatusRestoreExpired
+	StatusRestoreForbidden
 )
 
+// filter for documents which are not soft deleted
+var notDeleted = bson.M{"$exists": false}
+
 // All information about post in a single document, so no normalization
 // If for example user will change a username, then all his posts and comments should be updated
 type PostStorageImpl struct {
 	posts *mongo.Collection
 	users *sql.DB
+	bans  BanStorage // content of shadow banned users is hidden
 }
 
 func NewPostStorage(posts *mongo.Collection, users *sql.DB) PostStorage {
 	return &PostStorageImpl{
 		posts: posts,
 		users: users,
+		bans:  NewBanStorage(users),
+	}
+}
+
+// comments are searched by comments.search, see reindexComments;
+// search index which covered comments.body is replaced, comments stored before get the field;
+// content of users shadow banned before shadow flag was stored gets it
+func (ps *PostStorageImpl) EnsureIndexes(ctx context.Context) error {
+	shadowBanned, err := ps.bans.GetShadowBanned(ctx)
+	if err != nil {
+		return err
+	}
+	for _, authorID := range shadowBanned {
+		unflagged := bson.M{"$or": bson.A{
+			bson.M{"author._id": authorID, "shadow": bson.M{"$ne": true}},
+			bson.M{"comments": bson.M{"$elemMatch": bson.M{"author._id": authorID, "shadow": bson.M{"$ne": true}}}},
+		}}
+		if n, err := ps.posts.CountDocuments(ctx, unflagged); err != nil {
+			return err
+		} else if n > 0 {
+			if err := ps.SetShadow(authorID, true, ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	cursor, err := ps.posts.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	indexes := []struct {
+		Name    string `bson:"name"`
+		Weights bson.M `bson:"weights"`
+	}{}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if _, ok := index.Weights["comments.body"]; ok && index.Name == "search" {
+			if _, err := ps.posts.Indexes().DropOne(ctx, index.Name); err != nil {
+				return err
+			}
+		}
+	}
+	unindexed := bson.M{"comments": bson.M{"$elemMatch": bson.M{"search": bson.M{"$exists": false}}}}
+	if err := ps.reindexComments(unindexed, bson.M{"$literal": true}, ctx); err != nil {
+		return err
+	}
+
+	_, err = ps.posts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "category", Value: 1}, {Key: "canonical_url", Value: 1}}},
+		{Keys: bson.D{{Key: "image.key", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "image.thumbnail_key", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{
+			Keys: bson.D{{Key: "title", Value: "text"}, {Key: "text", Value: "text"}, {Key: "comments.search", Value: "text"}},
+			Options: options.Index().
+				SetName("search").
+				SetWeights(bson.M{"title": 10, "text": 5, "comments.search": 1}),
+		},
+	})
+	return err
+}
+
+// comments.search holds the body while everybody can see the comment and is empty otherwise,
+// it is indexed instead of the body, so deleted, removed and shadow comments are not found;
+// this sets it for comments for which which is true in posts matching filter
+func (ps *PostStorageImpl) reindexComments(filter, which bson.M, ctx context.Context) error {
+	visible := bson.M{"$and": bson.A{
+		bson.M{"$not": bson.A{"$$this.deleted"}},
+		bson.M{"$not": bson.A{"$$this.shadow"}},
+	}}
+	search := bson.M{"search": bson.M{"$cond": bson.A{visible, "$$this.body", ""}}}
+	update := bson.A{bson.M{"$set": bson.M{"comments": bson.M{"$map": bson.M{
+		"input": "$comments",
+		"in":    bson.M{"$cond": bson.A{which, bson.M{"$mergeObjects": bson.A{"$$this", search}}, "$$this"}},
+	}}}}}
+	_, err := ps.posts.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// content is flagged when it is made by a shadow banned user, so reads filter on the flag;
+// this is called when shadow ban of the author changes and flags everything they made before
+func (ps *PostStorageImpl) SetShadow(authorID string, shadow bool, ctx context.Context) error {
+	set := bson.M{"$set": bson.M{"shadow": true}}
+	if !shadow {
+		set = bson.M{"$unset": bson.M{"shadow": ""}}
 	}
+	if _, err := ps.posts.UpdateMany(ctx, bson.M{"author._id": authorID}, set); err != nil {
+		return err
+	}
+	filter := bson.M{"comments.author._id": authorID}
+	update := bson.M{"$set": bson.M{"comments.$[c].shadow": true}}
+	if !shadow {
+		update = bson.M{"$unset": bson.M{"comments.$[c].shadow": ""}}
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: bson.A{bson.M{"c.author._id": authorID}}})
+	if _, err := ps.posts.UpdateMany(ctx, filter, update, opts); err != nil {
+		return err
+	}
+	return ps.reindexComments(filter, bson.M{"$eq": bson.A{"$$this.author._id", authorID}}, ctx)
 }
 
-func (ps *PostStorageImpl) GetPost(postID string, ctx context.Context) (*Post, error) {
+func (ps *PostStorageImpl) GetPost(postID string, viewer Viewer, ctx context.Context) (*Post, error) {
 	hexPostID, err := primitive.ObjectIDFromHex(postID)
 	if err != nil {
 		return nil, err
 	}
 	post := &Post{}
 	err = ps.posts.FindOneAndUpdate(ctx, bson.M{"_id": hexPostID}, bson.M{"$inc": bson.M{"views": 1}}).Decode(post)
-	return post, err
+	if err != nil {
+		return nil, err
+	}
+	post.prepare(viewer)
+	if err := ps.setKarma([]*Post{post}, ctx); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// same as GetPost, but without counting a view; returns nil post if there is no such post, even soft deleted
+func (ps *PostStorageImpl) FindPost(postID string, ctx context.Context) (*Post, error) {
+	hexPostID, err := primitive.ObjectIDFromHex(postID)
+	if err != nil {
+		return nil, err
+	}
+	post := &Post{}
+	if err := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID}).Decode(post); err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// finds post which image or thumbnail is stored under the key, even soft deleted;
+// returns nil post if there is none
+func (ps *PostStorageImpl) FindImagePost(key string, ctx context.Context) (*Post, error) {
+	filter := bson.M{"$or": bson.A{bson.M{"image.key": key}, bson.M{"image.thumbnail_key": key}}}
+	post := &Post{}
+	opts := options.FindOne().SetProjection(bson.M{"deleted": 1, "image": 1})
+	if err := ps.posts.FindOne(ctx, filter, opts).Decode(post); err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// adds filter of shadow content, hidden authors and posts to the listing query
+func (v Viewer) filter(filter bson.M) bson.M {
+	filter["$nor"] = bson.A{bson.M{"shadow": true, "author._id": bson.M{"$ne": v.UserID}}}
+	if len(v.HiddenAuthors) > 0 {
+		filter["author._id"] = bson.M{"$nin": v.HiddenAuthors}
+	}
+	if len(v.HiddenPosts) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(v.HiddenPosts))
+		for _, postID := range v.HiddenPosts {
+			if id, err := primitive.ObjectIDFromHex(postID); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		if idFilter, ok := filter["_id"].(bson.M); ok {
+			idFilter["$nin"] = ids
+		} else if _, ok := filter["_id"]; !ok {
+			filter["_id"] = bson.M{"$nin": ids}
+		}
+	}
+	return filter
+}
+
+// makes post as viewer should see it:
+// deleted comments stay in the thread, only their content is hidden,
+// and their author and deletion details too unless viewer wrote the comment or moderates the category;
+// shadow comments of others and comments of hidden authors are dropped;
+// shadow vote of the viewer counts for them, so they do not notice anything
+func (post *Post) prepare(viewer Viewer) {
+	comments := post.Comments[:0]
+	for _, comment := range post.Comments {
+		if viewer.Hides(comment.Author, comment.Shadow) {
+			continue
+		}
+		if comment.Deleted != nil {
+			comment.Body, comment.BodyHTML = "[deleted]", deletedHTML
+			if comment.Author.ID != viewer.UserID && !viewer.ModeratesCategory(post.Category) {
+				comment.Author = Author{Username: "[deleted]"}
+				comment.Deleted = &Deletion{At: comment.Deleted.At}
+			}
+		}
+		comment.prepare(viewer)
+		comments = append(comments, comment)
+	}
+	post.Comments = comments
+	post.Score += shadowVote(post.Votes, viewer)
+	if post.TextHTML == "" {
+		post.TextHTML = markdown.Render(post.Text)
+	}
+	if post.Poll != nil {
+		post.Poll.prepare(viewer, time.Now())
+	}
+}
+
+// results are shown only to those who voted and to everyone after the poll closed;
+// shadow ballot counts for its author only
+func (poll *Poll) prepare(viewer Viewer, now time.Time) {
+	poll.Closed = poll.ClosesAt != nil && !now.Before(*poll.ClosesAt)
+	var own *Ballot
+	for i := range poll.Ballots {
+		if poll.Ballots[i].UserID == viewer.UserID {
+			own = &poll.Ballots[i]
+			poll.Voted = own.Options
+		}
+	}
+	if own == nil && !poll.Closed {
+		return
+	}
+	total := poll.Voters
+	for i := range poll.Options {
+		votes := poll.Options[i].Count
+		poll.Options[i].Votes = &votes
+	}
+	if own != nil && own.Shadow {
+		total++
+		for _, option := range own.Options {
+			*poll.Options[option].Votes++
+		}
+	}
+	poll.Total = &total
+}
+
+func (comment *Comment) prepare(viewer Viewer) {
+	comment.Score += shadowVote(comment.Votes, viewer)
+	if comment.BodyHTML == "" {
+		comment.BodyHTML = markdown.Render(comment.Body)
+	}
+}
+
+var deletedHTML = markdown.Render("[deleted]")
+
+// html is rendered once when content is written; this fills it for posts and comments
+// stored before that, for post which is shown as it is stored, without prepare
+func (post *Post) RenderMarkdown() {
+	if post.TextHTML == "" {
+		post.TextHTML = markdown.Render(post.Text)
+	}
+	for i := range post.Comments {
+		if post.Comments[i].BodyHTML == "" {
+			post.Comments[i].BodyHTML = markdown.Render(post.Comments[i].Body)
+		}
+	}
+}
+
+func shadowVote(votes []Vote, viewer Viewer) int {
+	for _, vote := range votes {
+		if vote.Shadow && vote.ID == viewer.UserID {
+			return vote.Vote
+		}
+	}
+	return 0
+}
+
+func (ps *PostStorageImpl) GetPosts(viewer Viewer, ctx context.Context) ([]*Post, error) {
+	cursor, err := ps.posts.Find(ctx, viewer.filter(bson.M{"deleted": notDeleted}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	return ps.getPostsByCursor(cursor, viewer, ctx)
+}
+
+func (ps *PostStorageImpl) GetPostsByCategory(category string, viewer Viewer, ctx context.Context) ([]*Post, error) {
+	// pinned posts go first
+	opts := options.Find().SetSort(bson.D{{Key: "pinned", Value: -1}, {Key: "_id", Value: 1}})
+	cursor, err := ps.posts.Find(ctx, viewer.filter(bson.M{"category": category, "deleted": notDeleted}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	return ps.getPostsByCursor(cursor, viewer, ctx)
+}
+
+func (ps *PostStorageImpl) GetPostsByUsername(username string, viewer Viewer, ctx context.Context) ([]*Post, error) {
+	cursor, err := ps.posts.Find(ctx, viewer.filter(bson.M{"author.username": username, "deleted": notDeleted}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	return ps.getPostsByCursor(cursor, viewer, ctx)
+}
+
+// not deleted posts among given ones, in no particular order; bad ids are skipped;
+// posts are asked for directly, so hidden ones are returned too
+func (ps *PostStorageImpl) GetPostsByIDs(postIDs []string, viewer Viewer, ctx context.Context) ([]*Post, error) {
+	viewer.HiddenPosts = nil
+	ids := make([]primitive.ObjectID, 0, len(postIDs))
+	for _, postID := range postIDs {
+		if id, err := primitive.ObjectIDFromHex(postID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	cursor, err := ps.posts.Find(ctx, viewer.filter(bson.M{"_id": bson.M{"$in": ids}, "deleted": notDeleted}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	return ps.getPostsByCursor(cursor, viewer, ctx)
 }
 
-func (ps *PostStorageImpl) GetPosts(ctx context.Context) ([]*Post, error) {
-	cursor, err := ps.posts.Find(ctx, bson.M{})
+// categories == nil means all categories
+func (ps *PostStorageImpl) GetFeed(categories []string, opts ListOptions, viewer Viewer, ctx context.Context) ([]*Post, error) {
+	filter := bson.M{"deleted": notDeleted}
+	if categories != nil {
+		filter["category"] = bson.M{"$in": categories}
+	}
+	return ps.getFeed(filter, opts, viewer, ctx)
+}
+
+func (ps *PostStorageImpl) GetFeedByUsername(username string, opts ListOptions, viewer Viewer, ctx context.Context) ([]*Post, error) {
+	return ps.getFeed(bson.M{"author.username": username, "deleted": notDeleted}, opts, viewer, ctx)
+}
+
+func (ps *PostStorageImpl) getFeed(filter bson.M, opts ListOptions, viewer Viewer, ctx context.Context) ([]*Post, error) {
+	filter = viewer.filter(filter)
+	// ObjectID starts with creation time, so sorting by _id is sorting by creation
+	sort := bson.D{{Key: "_id", Value: -1}}
+	if opts.Sort == SortTop {
+		sort = bson.D{{Key: "score", Value: -1}, {Key: "_id", Value: -1}}
+	}
+	findOpts := options.Find().SetSort(sort).SetSkip(int64(opts.Offset)).SetLimit(int64(opts.Limit))
+	cursor, err := ps.posts.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	return ps.getPostsByCursor(cursor, viewer, ctx)
+}
+
+// comments of the user across all posts, newest first
+func (ps *PostStorageImpl) GetCommentsByUsername(username string, opts ListOptions, viewer Viewer, ctx context.Context) ([]*UserComment, error) {
+	pipeline := bson.A{
+		bson.M{"$match": viewer.filter(bson.M{"comments.author.username": username, "deleted": notDeleted})},
+		bson.M{"$unwind": "$comments"},
+		bson.M{"$match": bson.M{"comments.author.username": username, "comments.deleted": notDeleted}},
+		bson.M{"$replaceRoot": bson.M{"newRoot": bson.M{"$mergeObjects": bson.A{
+			"$comments",
+			bson.M{"post_id": "$_id", "post_title": "$title", "category": "$category"},
+		}}}},
+		bson.M{"$sort": bson.M{"_id": -1}},
+		bson.M{"$skip": opts.Offset},
+		bson.M{"$limit": opts.Limit},
+	}
+	cursor, err := ps.posts.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
-	return getPostsByCursor(cursor, ctx)
+	comments := make([]*UserComment, 0, opts.Limit)
+	for cursor.Next(ctx) {
+		comment := &UserComment{}
+		if err := cursor.Decode(comment); err != nil {
+			return nil, err
+		}
+		if viewer.Hides(comment.Author, comment.Shadow) {
+			continue
+		}
+		comment.Permalink = "/a/" + comment.Category + "/" + hex.EncodeToString(comment.PostID)
+		comment.prepare(viewer)
+		comments = append(comments, comment)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	authors := make(map[string][]*Author)
+	for _, comment := range comments {
+		authors[comment.Author.ID] = append(authors[comment.Author.ID], &comment.Author)
+	}
+	if err := ps.fillKarma(authors, ctx); err != nil {
+		return nil, err
+	}
+	return comments, nil
 }
 
-func (ps *PostStorageImpl) GetPostsByCategory(category string, ctx context.Context) ([]*Post, error) {
-	cursor, err := ps.posts.Find(ctx, bson.M{"category": category})
+// ids of not deleted posts in category with the same link made since given time which viewer can see, newest first
+func (ps *PostStorageImpl) FindReposts(category, canonicalURL string, since time.Time, viewer Viewer, ctx context.Context) ([]string, error) {
+	filter := viewer.filter(bson.M{
+		"category":      category,
+		"canonical_url": canonicalURL,
+		"deleted":       notDeleted,
+		"_id":           bson.M{"$gte": primitive.NewObjectIDFromTimestamp(since)},
+	})
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetProjection(bson.M{"_id": 1})
+	cursor, err := ps.posts.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
-	return getPostsByCursor(cursor, ctx)
+	ids := make([]string, 0)
+	for cursor.Next(ctx) {
+		post := struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}{}
+		if err := cursor.Decode(&post); err != nil {
+			return nil, err
+		}
+		ids = append(ids, post.ID.Hex())
+	}
+	return ids, cursor.Err()
 }
 
-func (ps *PostStorageImpl) GetPostsByUsername(username string, ctx context.Context) ([]*Post, error) {
-	cursor, err := ps.posts.Find(ctx, bson.M{"author.username": username})
+// most relevant posts go first; mongo text index is kept in sync by mongo itself
+func (ps *PostStorageImpl) Search(opts SearchOptions, viewer Viewer, ctx context.Context) ([]*SearchResult, error) {
+	filter := bson.M{
+		"$text":   bson.M{"$search": opts.Query},
+		"deleted": notDeleted,
+	}
+	if opts.Category != "" {
+		filter["category"] = opts.Category
+	}
+	if opts.Author != "" {
+		filter["author.username"] = opts.Author
+	}
+	if opts.Type != "" {
+		filter["type"] = opts.Type
+	}
+	created := bson.M{}
+	if !opts.Since.IsZero() {
+		created["$gte"] = primitive.NewObjectIDFromTimestamp(opts.Since)
+	}
+	if !opts.Until.IsZero() {
+		created["$lt"] = primitive.NewObjectIDFromTimestamp(opts.Until)
+	}
+	if len(created) > 0 {
+		filter["_id"] = created
+	}
+	filter = viewer.filter(filter)
+
+	relevance := bson.M{"$meta": "textScore"}
+	findOpts := options.Find().
+		SetProjection(bson.M{"relevance": relevance}).
+		SetSort(bson.D{{Key: "relevance", Value: relevance}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(opts.Offset)).
+		SetLimit(int64(opts.Limit))
+	cursor, err := ps.posts.Find(ctx, filter, findOpts)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
-	return getPostsByCursor(cursor, ctx)
+	results := make([]*SearchResult, 0, 10)
+	for cursor.Next(ctx) {
+		result := &SearchResult{}
+		if err := cursor.Decode(result); err != nil {
+			return nil, err
+		}
+		result.prepare(viewer)
+		results = append(results, result)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	posts := make([]*Post, 0, len(results))
+	for _, result := range results {
+		posts = append(posts, &result.Post)
+	}
+	if err := ps.setKarma(posts, ctx); err != nil {
+		return nil, err
+	}
+	return results, nil
 }
 
-func getPostsByCursor(cursor *mongo.Cursor, ctx context.Context) ([]*Post, error) {
+func (ps *PostStorageImpl) getPostsByCursor(cursor *mongo.Cursor, viewer Viewer, ctx context.Context) ([]*Post, error) {
 	posts := make([]*Post, 0, 10)
 	defer cursor.Close(ctx)
 	for cursor.Next(ctx) {
@@ -80,17 +521,63 @@ func getPostsByCursor(cursor *mongo.Cursor, ctx context.Context) ([]*Post, error
 		if err := cursor.Decode(post); err != nil {
 			return nil, err
 		}
+		post.prepare(viewer)
 		posts = append(posts, post)
 	}
+	if err := ps.setKarma(posts, ctx); err != nil {
+		return nil, err
+	}
 	return posts, nil
 }
 
+// sets karma of authors of posts and their comments
+func (ps *PostStorageImpl) setKarma(posts []*Post, ctx context.Context) error {
+	authors := make(map[string][]*Author)
+	for _, post := range posts {
+		authors[post.Author.ID] = append(authors[post.Author.ID], &post.Author)
+		for i := range post.Comments {
+			author := &post.Comments[i].Author
+			authors[author.ID] = append(authors[author.ID], author)
+		}
+	}
+	return ps.fillKarma(authors, ctx)
+}
+
+// karma is kept in postgres, so it is loaded for all authors by one query
+func (ps *PostStorageImpl) fillKarma(authors map[string][]*Author, ctx context.Context) error {
+	ids := make([][]byte, 0, len(authors))
+	for id := range authors {
+		if rawID, err := hex.DecodeString(id); err == nil && len(rawID) > 0 {
+			ids = append(ids, rawID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := ps.users.QueryContext(ctx, "SELECT user_id, post_karma + comment_karma FROM users WHERE user_id = ANY($1);", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rawID []byte
+		var karma int
+		if err := rows.Scan(&rawID, &karma); err != nil {
+			return err
+		}
+		for _, author := range authors[hex.EncodeToString(rawID)] {
+			author.Karma = &karma
+		}
+	}
+	return rows.Err()
+}
+
 func (ps *PostStorageImpl) CheckPostExist(postID string, ctx context.Context) (bool, error) {
 	hexPostID, err := primitive.ObjectIDFromHex(postID)
 	if err != nil {
 		return false, err
 	}
-	if err := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID}).Err(); err == mongo.ErrNoDocuments {
+	if err := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID, "deleted": notDeleted}).Err(); err == mongo.ErrNoDocuments {
 		return false, nil
 	} else if err != nil {
 		return false, err
@@ -108,13 +595,13 @@ func (ps *PostStorageImpl) CheckCommentExist(postID, commentID string, ctx conte
 	if err != nil {
 		return StatusError, err
 	}
-	if err := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID}).Decode(post); err == mongo.ErrNoDocuments {
+	if err := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID, "deleted": notDeleted}).Decode(post); err == mongo.ErrNoDocuments {
 		return StatusPostNotExist, nil
 	} else if err != nil {
 		return StatusError, err
 	}
 	for _, comment := range post.Comments {
-		if bytes.Equal(comment.ID[:], hexCommentID[:]) {
+		if bytes.Equal(comment.ID[:], hexCommentID[:]) && comment.Deleted == nil {
 			return StatusCommentOK, nil
 		}
 	}
@@ -166,29 +653,49 @@ func (ps *PostStorageImpl) CheckCommentOwner(postID, commentID string, user User
 	return true, nil
 }
 
-func (ps *PostStorageImpl) MakeComment(postID, comment string, user User, ctx context.Context) error {
+// empty parentID means the comment is a reply to the post itself
+func (ps *PostStorageImpl) MakeComment(postID, parentID, comment string, user User, ctx context.Context) (string, error) {
 	hexPostID, err := primitive.ObjectIDFromHex(postID)
 	if err != nil {
-		return err
+		return "", err
 	}
-	update := bson.M{
-		"$push": bson.M{
-			"comments": bson.M{
-				"created": time.Now().Format(time.RFC3339),
-				"author":  bson.M{"username": user.Username, "_id": user.UserID},
-				"body":    comment,
-				"_id":     primitive.NewObjectID(),
-			},
-		}}
+	shadow, err := ps.bans.IsShadowBanned(user.UserID, ctx)
+	if err != nil {
+		return "", err
+	}
+	search := comment
+	if shadow {
+		search = ""
+	}
+	commentID := primitive.NewObjectID()
+	newComment := bson.M{
+		"created":   time.Now().Format(time.RFC3339),
+		"author":    bson.M{"username": user.Username, "_id": user.UserID},
+		"body":      comment,
+		"body_html": markdown.Render(comment),
+		"search":    search,
+		"_id":       commentID,
+	}
+	if shadow {
+		newComment["shadow"] = true
+	}
+	if parentID != "" {
+		newComment["parent_id"] = parentID
+	}
+	update := bson.M{"$push": bson.M{"comments": newComment}}
 	if res, err := ps.posts.UpdateByID(ctx, hexPostID, update); err != nil {
-		return err
+		return "", err
 	} else if res.MatchedCount != 1 || res.ModifiedCount != 1 {
-		return errors.New("mongo: cannot make comment")
+		return "", errors.New("mongo: cannot make comment")
 	}
-	return nil
+	return commentID.Hex(), nil
 }
 
 func (ps *PostStorageImpl) MakePost(newPost *NewPost, user User, ctx context.Context) (string, error) {
+	shadow, err := ps.bans.IsShadowBanned(user.UserID, ctx)
+	if err != nil {
+		return "", err
+	}
 	post := &Post{
 		Type:  newPost.Type,
 		Title: newPost.Title,
@@ -201,7 +708,13 @@ func (ps *PostStorageImpl) MakePost(newPost *NewPost, user User, ctx context.Con
 		Comments: []Comment{},
 		Category: newPost.Category,
 		Text:     newPost.Text,
+		TextHTML: markdown.Render(newPost.Text),
+		Image:    newPost.Image,
+		Poll:     newPoll(newPost.Poll),
 		Created:  time.Now().Format(time.RFC3339),
+		Shadow:   shadow,
+
+		CanonicalURL: newPost.CanonicalURL,
 	}
 	res, err := ps.posts.InsertOne(ctx, post)
 	if err != nil {
@@ -215,8 +728,23 @@ func (ps *PostStorageImpl) MakePost(newPost *NewPost, user User, ctx context.Con
 	return str, nil
 }
 
-func (ps *PostStorageImpl) DeleteComment(postID, commentID string, ctx context.Context) error {
-	// log.Printf("%s/%s\n", postID, commentID)
+func newPoll(poll *NewPoll) *Poll {
+	if poll == nil {
+		return nil
+	}
+	options := make([]PollOption, 0, len(poll.Options))
+	for _, text := range poll.Options {
+		options = append(options, PollOption{Text: text})
+	}
+	return &Poll{
+		Options:  options,
+		Multiple: poll.Multiple,
+		ClosesAt: poll.ClosesAt,
+		Ballots:  []Ballot{},
+	}
+}
+
+func (ps *PostStorageImpl) DeleteComment(postID, commentID string, deletion Deletion, ctx context.Context) error {
 	hexPostID, err := primitive.ObjectIDFromHex(postID)
 	if err != nil {
 		return err
@@ -225,11 +753,11 @@ func (ps *PostStorageImpl) DeleteComment(postID, commentID string, ctx context.C
 	if err != nil {
 		return err
 	}
-	filter := bson.M{"_id": hexPostID}
-	update := bson.M{"$pull": bson.M{
-		"comments": bson.M{
-			"_id": bson.M{"$in": bson.A{hexCommentID}}},
-	}}
+	filter := bson.M{"_id": hexPostID, "comments": bson.M{"$elemMatch": bson.M{
+		"_id":     hexCommentID,
+		"deleted": notDeleted,
+	}}}
+	update := bson.M{"$set": bson.M{"comments.$.deleted": deletion, "comments.$.search": ""}}
 
 	if res, err := ps.posts.UpdateOne(ctx, filter, update); err != nil {
 		return err
@@ -239,83 +767,445 @@ func (ps *PostStorageImpl) DeleteComment(postID, commentID string, ctx context.C
 	return nil
 }
 
-func (ps *PostStorageImpl) DeletePost(postID string, ctx context.Context) error {
+func (ps *PostStorageImpl) DeletePost(postID string, deletion Deletion, ctx context.Context) error {
 	hexPostID, err := primitive.ObjectIDFromHex(postID)
 	if err != nil {
 		return err
 	}
-	if res, err := ps.posts.DeleteOne(ctx, bson.M{"_id": hexPostID}); err != nil {
+	filter := bson.M{"_id": hexPostID, "deleted": notDeleted}
+	if res, err := ps.posts.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"deleted": deletion}}); err != nil {
 		return err
-	} else if res.DeletedCount != 1 {
+	} else if res.ModifiedCount != 1 {
 		return errors.New("cannot delete post")
 	}
 	return nil
 }
 
-func (ps *PostStorageImpl) Rate(postID string, rating int, user User, ctx context.Context) error {
-	post := &Post{}
+// only the author can restore, only their own deletion (not a removal by someone else) and only within window
+func checkRestore(author Author, deletion *Deletion, user User, window time.Duration) int {
+	if deletion == nil {
+		return StatusNotDeleted
+	} else if author.ID != user.UserID || deletion.By.ID != user.UserID {
+		return StatusRestoreForbidden
+	} else if time.Since(deletion.At) > window {
+		return StatusRestoreExpired
+	}
+	return StatusRestoreOK
+}
+
+func (ps *PostStorageImpl) RestoreComment(postID, commentID string, user User, window time.Duration, ctx context.Context) (int, error) {
 	hexPostID, err := primitive.ObjectIDFromHex(postID)
 	if err != nil {
-		return err
+		return StatusError, err
 	}
-	res := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID})
-	if err := res.Decode(post); err != nil {
-		return err
+	hexCommentID, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		return StatusError, err
+	}
+	post := &Post{}
+	if err := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID, "deleted": notDeleted}).Decode(post); err == mongo.ErrNoDocuments {
+		return StatusPostNotExist, nil
+	} else if err != nil {
+		return StatusError, err
 	}
-	post.ID = nil // can't update id
-	voteExist := false
-	for i := range post.Votes {
-		if post.Votes[i].ID == user.UserID {
-			post.Score += rating - post.Votes[i].Vote
-			post.Votes[i].Vote = rating
-			voteExist = true
+	var comment *Comment
+	for i := range post.Comments {
+		if bytes.Equal(post.Comments[i].ID[:], hexCommentID[:]) {
+			comment = &post.Comments[i]
 			break
 		}
 	}
-	if !voteExist {
-		post.Score += rating
-		post.Votes = append(post.Votes, Vote{user.UserID, rating})
+	if comment == nil {
+		return StatusCommentNotExist, nil
+	}
+	if status := checkRestore(comment.Author, comment.Deleted, user, window); status != StatusRestoreOK {
+		return status, nil
+	}
+
+	filter := bson.M{"_id": hexPostID, "comments._id": hexCommentID}
+	update := bson.M{"$unset": bson.M{"comments.$.deleted": ""}}
+	if res, err := ps.posts.UpdateOne(ctx, filter, update); err != nil {
+		return StatusError, err
+	} else if res.ModifiedCount != 1 {
+		return StatusError, errors.New("cannot restore comment")
+	}
+	if err := ps.reindexComment(hexPostID, hexCommentID, ctx); err != nil {
+		return StatusError, err
+	}
+	return StatusRestoreOK, nil
+}
+
+func (ps *PostStorageImpl) RestorePost(postID string, user User, window time.Duration, ctx context.Context) (int, error) {
+	hexPostID, err := primitive.ObjectIDFromHex(postID)
+	if err != nil {
+		return StatusError, err
+	}
+	post := &Post{}
+	if err := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID}).Decode(post); err == mongo.ErrNoDocuments {
+		return StatusPostNotExist, nil
+	} else if err != nil {
+		return StatusError, err
+	}
+	if status := checkRestore(post.Author, post.Deleted, user, window); status != StatusRestoreOK {
+		return status, nil
+	}
+
+	if res, err := ps.posts.UpdateByID(ctx, hexPostID, bson.M{"$unset": bson.M{"deleted": ""}}); err != nil {
+		return StatusError, err
+	} else if res.ModifiedCount != 1 {
+		return StatusError, errors.New("cannot restore post")
+	}
+	return StatusRestoreOK, nil
+}
+
+// permanently removes posts and comments which were soft deleted before given time;
+// comments with replies keep a tombstone without body and author, so the thread keeps its shape,
+// and are removed by a later purge once their replies are gone;
+// returns blob keys of purged image posts, blobs are not removed here
+func (ps *PostStorageImpl) PurgeDeleted(before time.Time, ctx context.Context) ([]string, error) {
+	expired := bson.M{"$lt": before}
+	filter := bson.M{"deleted.at": expired, "image": bson.M{"$exists": true}}
+	cur, err := ps.posts.Find(ctx, filter, options.Find().SetProjection(bson.M{"image": 1}))
+	if err != nil {
+		return nil, err
+	}
+	posts := []*Post{}
+	if err := cur.All(ctx, &posts); err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, post := range posts {
+		keys = append(keys, post.Image.Key, post.Image.ThumbnailKey)
+	}
+
+	if _, err := ps.posts.DeleteMany(ctx, bson.M{"deleted.at": expired}); err != nil {
+		return nil, err
+	}
+	filter = bson.M{"comments.deleted.at": expired}
+	isExpired := bson.M{"$and": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": "$$this.deleted.at"}, "date"}},
+		bson.M{"$lt": bson.A{"$$this.deleted.at", before}},
+	}}
+	hasReplies := bson.M{"$in": bson.A{bson.M{"$toString": "$$this._id"}, "$purge_parents"}}
+	tombstone := bson.M{"$mergeObjects": bson.A{"$$this", bson.M{
+		"author":    Author{Username: "[deleted]"},
+		"body":      "",
+		"body_html": "",
+		"search":    "",
+		"votes":     bson.A{},
+		"deleted":   bson.M{"at": "$$this.deleted.at"},
+	}}}
+	update := bson.A{
+		bson.M{"$set": bson.M{"purge_parents": "$comments.parent_id"}},
+		bson.M{"$set": bson.M{"comments": bson.M{"$filter": bson.M{
+			"input": "$comments",
+			"cond":  bson.M{"$not": bson.A{bson.M{"$and": bson.A{isExpired, bson.M{"$not": bson.A{hasReplies}}}}}},
+		}}}},
+		bson.M{"$set": bson.M{"comments": bson.M{"$map": bson.M{
+			"input": "$comments",
+			"in":    bson.M{"$cond": bson.A{isExpired, tombstone, "$$this"}},
+		}}}},
+		bson.M{"$unset": "purge_parents"},
+	}
+	if _, err := ps.posts.UpdateMany(ctx, filter, update); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ballot is pushed only if there is no ballot of the user yet and the poll is open,
+// so concurrent votes of one user cannot both count
+func (ps *PostStorageImpl) VotePoll(postID string, options []int, user User, ctx context.Context) (bool, error) {
+	hexPostID, err := primitive.ObjectIDFromHex(postID)
+	if err != nil {
+		return false, err
+	}
+	shadow, err := ps.bans.IsShadowBanned(user.UserID, ctx)
+	if err != nil {
+		return false, err
+	}
+	filter := bson.M{
+		"_id":                  hexPostID,
+		"deleted":              notDeleted,
+		"poll":                 bson.M{"$exists": true},
+		"poll.ballots.user_id": bson.M{"$ne": user.UserID},
+		"$or": bson.A{
+			bson.M{"poll.closes_at": bson.M{"$exists": false}},
+			bson.M{"poll.closes_at": bson.M{"$gt": time.Now()}},
+		},
+	}
+	update := bson.M{"$push": bson.M{"poll.ballots": Ballot{UserID: user.UserID, Options: options, Shadow: shadow}}}
+	if !shadow {
+		inc := bson.M{"poll.voters": 1}
+		for _, option := range options {
+			inc["poll.options."+strconv.Itoa(option)+".count"] = 1
+		}
+		update["$inc"] = inc
+	}
+	res, err := ps.posts.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
 	}
-	post.UpvotePercentage = int(float64(post.Score*len(post.Votes)) / float64(len(post.Votes)) * .5 * 100)
-	if res, err := ps.posts.ReplaceOne(ctx, bson.M{"_id": hexPostID}, *post); err != nil {
+	return res.ModifiedCount == 1, nil
+}
+
+func (ps *PostStorageImpl) Rate(postID string, rating int, user User, ctx context.Context) error {
+	return ps.votePost(postID, rating, user, ctx)
+}
+
+func (ps *PostStorageImpl) Unrate(postID string, user User, ctx context.Context) error {
+	return ps.votePost(postID, 0, user, ctx)
+}
+
+// concurrent votes of the same user can make conditional update miss, then it is tried again
+const maxVoteAttempts = 5
+
+// rating 0 removes the vote; update is conditional on the vote read before,
+// so concurrent changes of the post are never overwritten
+func (ps *PostStorageImpl) votePost(postID string, rating int, user User, ctx context.Context) error {
+	hexPostID, err := primitive.ObjectIDFromHex(postID)
+	if err != nil {
 		return err
-	} else if res.MatchedCount != 1 || res.ModifiedCount != 1 {
-		return errors.New("cannot replace post")
+	}
+	shadow, err := ps.bans.IsShadowBanned(user.UserID, ctx)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < maxVoteAttempts; attempt++ {
+		post := &Post{}
+		opts := options.FindOne().SetProjection(bson.M{"votes": 1, "author": 1})
+		if err := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID}, opts).Decode(post); err != nil {
+			return err
+		}
+		old := findVote(post.Votes, user.UserID)
+		if old == nil && rating == 0 {
+			return nil
+		}
+		delta := voteDelta(old, rating, shadow)
+		filter := bson.M{"_id": hexPostID}
+		update := bson.M{"$inc": bson.M{"score": delta}}
+		switch {
+		case old == nil:
+			filter["votes._id"] = bson.M{"$ne": user.UserID}
+			update["$push"] = bson.M{"votes": Vote{ID: user.UserID, Vote: rating, Shadow: shadow}}
+		case rating == 0:
+			filter["votes"] = bson.M{"$elemMatch": voteMatch(old)}
+			update["$pull"] = bson.M{"votes": bson.M{"_id": user.UserID}}
+		default:
+			filter["votes"] = bson.M{"$elemMatch": voteMatch(old)}
+			update["$set"] = bson.M{"votes.$.vote": rating, "votes.$.shadow": shadow}
+		}
+		res, err := ps.posts.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		} else if res.MatchedCount == 0 {
+			continue
+		}
+		if err := ps.updateUpvotePercentage(hexPostID, ctx); err != nil {
+			return err
+		}
+		return ps.addKarma(addPostKarma, post.Author.ID, delta, ctx)
+	}
+	return errors.New("cannot vote for post")
+}
+
+// derived from the score and votes stored at the moment of update, so it cannot be stale
+func (ps *PostStorageImpl) updateUpvotePercentage(postID primitive.ObjectID, ctx context.Context) error {
+	update := bson.A{bson.M{"$set": bson.M{"upvotepercentage": bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{bson.M{"$size": "$votes"}, 0}},
+		bson.M{"$trunc": bson.M{"$multiply": bson.A{"$score", 50}}},
+		"$upvotepercentage",
+	}}}}}
+	_, err := ps.posts.UpdateByID(ctx, postID, update)
+	return err
+}
+
+func findVote(votes []Vote, userID string) *Vote {
+	for i := range votes {
+		if votes[i].ID == userID {
+			return &votes[i]
+		}
 	}
 	return nil
 }
 
-func (ps *PostStorageImpl) Unrate(postID string, user User, ctx context.Context) error {
-	post := &Post{}
+// vote exactly as it was read; shadow is omitted from the document when false
+func voteMatch(vote *Vote) bson.M {
+	match := bson.M{"_id": vote.ID, "vote": vote.Vote, "shadow": true}
+	if !vote.Shadow {
+		match["shadow"] = bson.M{"$ne": true}
+	}
+	return match
+}
+
+// change of the score when old vote becomes rating, 0 removes the vote;
+// shadow votes do not count, and shadow flag follows the current ban,
+// so a vote counted before the ban stops counting as soon as the banned user changes it
+func voteDelta(old *Vote, rating int, shadow bool) int {
+	delta := 0
+	if old != nil && !old.Shadow {
+		delta -= old.Vote
+	}
+	if rating != 0 && !shadow {
+		delta += rating
+	}
+	return delta
+}
+
+func (ps *PostStorageImpl) RateComment(postID, commentID string, rating int, user User, ctx context.Context) error {
+	return ps.voteComment(postID, commentID, rating, user, ctx)
+}
+
+func (ps *PostStorageImpl) UnrateComment(postID, commentID string, user User, ctx context.Context) error {
+	return ps.voteComment(postID, commentID, 0, user, ctx)
+}
+
+// rating 0 removes the vote; like votePost, update is conditional on the vote read before
+func (ps *PostStorageImpl) voteComment(postID, commentID string, rating int, user User, ctx context.Context) error {
 	hexPostID, err := primitive.ObjectIDFromHex(postID)
 	if err != nil {
 		return err
 	}
-	res := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID})
-	if err := res.Decode(post); err != nil {
+	hexCommentID, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		return err
+	}
+	shadow, err := ps.bans.IsShadowBanned(user.UserID, ctx)
+	if err != nil {
 		return err
 	}
-	post.ID = nil // can't update id
-	log.Printf("%v; %s", post.Votes, user.UserID)
-	for i := range post.Votes {
-		if post.Votes[i].ID == user.UserID {
-			post.Score -= post.Votes[i].Vote
-			if i+1 < len(post.Votes) {
-				post.Votes = append(post.Votes[:i], post.Votes[i+1:]...)
-			} else {
-				post.Votes = post.Votes[:i]
-			}
-			if len(post.Votes) > 0 {
-				post.UpvotePercentage = int(float64(post.Score*len(post.Votes)) / float64(len(post.Votes)) * .5 * 100)
+	for attempt := 0; attempt < maxVoteAttempts; attempt++ {
+		post := &Post{}
+		opts := options.FindOne().SetProjection(bson.M{"comments": 1})
+		if err := ps.posts.FindOne(ctx, bson.M{"_id": hexPostID}, opts).Decode(post); err != nil {
+			return err
+		}
+		var comment *Comment
+		for i := range post.Comments {
+			if bytes.Equal(post.Comments[i].ID, hexCommentID[:]) && post.Comments[i].Deleted == nil {
+				comment = &post.Comments[i]
+				break
 			}
-			break
 		}
+		if comment == nil {
+			return errors.New("cannot find comment")
+		}
+		old := findVote(comment.Votes, user.UserID)
+		if old == nil && rating == 0 {
+			return nil
+		}
+		delta := voteDelta(old, rating, shadow)
+		match := bson.M{"_id": hexCommentID, "deleted": notDeleted}
+		update := bson.M{"$inc": bson.M{"comments.$[c].score": delta}}
+		filters := []interface{}{bson.M{"c._id": hexCommentID}}
+		switch {
+		case old == nil:
+			match["votes._id"] = bson.M{"$ne": user.UserID}
+			update["$push"] = bson.M{"comments.$[c].votes": Vote{ID: user.UserID, Vote: rating, Shadow: shadow}}
+		case rating == 0:
+			match["votes"] = bson.M{"$elemMatch": voteMatch(old)}
+			update["$pull"] = bson.M{"comments.$[c].votes": bson.M{"_id": user.UserID}}
+		default:
+			match["votes"] = bson.M{"$elemMatch": voteMatch(old)}
+			update["$set"] = bson.M{"comments.$[c].votes.$[v].vote": rating, "comments.$[c].votes.$[v].shadow": shadow}
+			filters = append(filters, bson.M{"v._id": user.UserID})
+		}
+		filter := bson.M{"_id": hexPostID, "comments": bson.M{"$elemMatch": match}}
+		updateOpts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: filters})
+		res, err := ps.posts.UpdateOne(ctx, filter, update, updateOpts)
+		if err != nil {
+			return err
+		} else if res.MatchedCount == 0 {
+			continue
+		}
+		return ps.addKarma(addCommentKarma, comment.Author.ID, delta, ctx)
 	}
+	return errors.New("cannot vote for comment")
+}
 
-	if res, err := ps.posts.ReplaceOne(ctx, bson.M{"_id": hexPostID}, *post); err != nil {
+const (
+	addPostKarma    = "UPDATE users SET post_karma = post_karma + $1 WHERE user_id = $2;"
+	addCommentKarma = "UPDATE users SET comment_karma = comment_karma + $1 WHERE user_id = $2;"
+)
+
+// karma is changed together with the score, so it never has to be recounted
+func (ps *PostStorageImpl) addKarma(query, userID string, delta int, ctx context.Context) error {
+	if delta == 0 {
+		return nil
+	}
+	rawID, err := hex.DecodeString(userID)
+	if err != nil {
 		return err
-	} else if res.MatchedCount != 1 || res.ModifiedCount != 1 {
-		return errors.New("cannot replace post")
+	}
+	_, err = ps.users.ExecContext(ctx, query, delta, rawID)
+	return err
+}
+
+//-------------------------------------Moderation--------------------------------------//
+
+func (ps *PostStorageImpl) ApproveComment(postID, commentID string, approval Approval, ctx context.Context) error {
+	hexPostID, err := primitive.ObjectIDFromHex(postID)
+	if err != nil {
+		return err
+	}
+	hexCommentID, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": hexPostID, "comments._id": hexCommentID}
+	update := bson.M{
+		"$set":   bson.M{"comments.$.approved": approval},
+		"$unset": bson.M{"comments.$.deleted": ""},
+	}
+	if res, err := ps.posts.UpdateOne(ctx, filter, update); err != nil {
+		return err
+	} else if res.MatchedCount != 1 {
+		return errors.New("cannot approve comment")
+	}
+	return ps.reindexComment(hexPostID, hexCommentID, ctx)
+}
+
+func (ps *PostStorageImpl) reindexComment(postID, commentID primitive.ObjectID, ctx context.Context) error {
+	return ps.reindexComments(bson.M{"_id": postID}, bson.M{"$eq": bson.A{"$$this._id", commentID}}, ctx)
+}
+
+func (ps *PostStorageImpl) ApprovePost(postID string, approval Approval, ctx context.Context) error {
+	update := bson.M{
+		"$set":   bson.M{"approved": approval},
+		"$unset": bson.M{"deleted": ""},
+	}
+	return ps.updatePost(postID, update, ctx)
+}
+
+func (ps *PostStorageImpl) LockPost(postID string, locked bool, ctx context.Context) error {
+	return ps.updatePost(postID, bson.M{"$set": bson.M{"locked": locked}}, ctx)
+}
+
+func (ps *PostStorageImpl) PinPost(postID string, pinned bool, ctx context.Context) error {
+	return ps.updatePost(postID, bson.M{"$set": bson.M{"pinned": pinned}}, ctx)
+}
+
+func (ps *PostStorageImpl) MarkPost(postID string, nsfw, spoiler bool, ctx context.Context) error {
+	return ps.updatePost(postID, bson.M{"$set": bson.M{"nsfw": nsfw, "spoiler": spoiler}}, ctx)
+}
+
+func (ps *PostStorageImpl) SetFlair(postID, flair string, ctx context.Context) error {
+	return ps.updatePost(postID, bson.M{"$set": bson.M{"flair": flair}}, ctx)
+}
+
+func (ps *PostStorageImpl) SetPreview(postID string, preview Preview, ctx context.Context) error {
+	return ps.updatePost(postID, bson.M{"$set": bson.M{"preview": preview}}, ctx)
+}
+
+func (ps *PostStorageImpl) updatePost(postID string, update bson.M, ctx context.Context) error {
+	hexPostID, err := primitive.ObjectIDFromHex(postID)
+	if err != nil {
+		return err
+	}
+	if res, err := ps.posts.UpdateByID(ctx, hexPostID, update); err != nil {
+		return err
+	} else if res.MatchedCount != 1 {
+		return errors.New("cannot update post")
 	}
 	return nil
 }
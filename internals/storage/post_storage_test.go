@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPollPrepare(t *testing.T) {
+	now := time.Now()
+	later, earlier := now.Add(time.Hour), now.Add(-time.Hour)
+	newPoll := func(closesAt *time.Time) *Poll {
+		return &Poll{
+			Options:  []PollOption{{Text: "yes", Count: 3}, {Text: "no", Count: 1}},
+			ClosesAt: closesAt,
+			Voters:   4,
+			Ballots: []Ballot{
+				{UserID: "voter", Options: []int{0}},
+				{UserID: "shadow", Options: []int{1}, Shadow: true},
+			},
+		}
+	}
+	tests := []struct {
+		name   string
+		poll   *Poll
+		viewer string
+		closed bool
+		voted  []int
+		votes  []int // nil when results are hidden
+		total  int
+	}{
+		{"open, anonymous", newPoll(&later), "", false, nil, nil, 0},
+		{"open, not voted", newPoll(nil), "other", false, nil, nil, 0},
+		{"open, voted", newPoll(&later), "voter", false, []int{0}, []int{3, 1}, 4},
+		{"open, shadow ballot counts for its author", newPoll(nil), "shadow", false, []int{1}, []int{3, 2}, 5},
+		{"closed, anonymous", newPoll(&earlier), "", true, nil, []int{3, 1}, 4},
+		{"closed now", newPoll(&now), "other", true, nil, []int{3, 1}, 4},
+		{"closed, shadow ballot", newPoll(&earlier), "shadow", true, []int{1}, []int{3, 2}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.poll.prepare(Viewer{UserID: tt.viewer}, now)
+			if tt.poll.Closed != tt.closed {
+				t.Errorf("Closed = %v, want %v", tt.poll.Closed, tt.closed)
+			}
+			if !reflect.DeepEqual(tt.poll.Voted, tt.voted) {
+				t.Errorf("Voted = %v, want %v", tt.poll.Voted, tt.voted)
+			}
+			if tt.votes == nil {
+				if tt.poll.Total != nil || tt.poll.Options[0].Votes != nil || tt.poll.Options[1].Votes != nil {
+					t.Errorf("results are shown before voting: total %v", tt.poll.Total)
+				}
+				return
+			}
+			if tt.poll.Total == nil || *tt.poll.Total != tt.total {
+				t.Errorf("Total = %v, want %d", tt.poll.Total, tt.total)
+			}
+			for i, want := range tt.votes {
+				if votes := tt.poll.Options[i].Votes; votes == nil || *votes != want {
+					t.Errorf("Options[%d].Votes = %v, want %d", i, votes, want)
+				}
+			}
+			// stored counts stay as they are
+			if tt.poll.Options[1].Count != 1 {
+				t.Errorf("Options[1].Count = %d, want 1", tt.poll.Options[1].Count)
+			}
+		})
+	}
+}
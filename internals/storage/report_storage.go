@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Every report is a separate document, queue is built by aggregation of unresolved ones
+// Empty comment_id means report is about the post itself
+type ReportStorageImpl struct {
+	reports *mongo.Collection
+}
+
+func NewReportStorage(reports *mongo.Collection) ReportStorage {
+	return &ReportStorageImpl{
+		reports: reports,
+	}
+}
+
+var unresolved = bson.M{"$exists": false}
+
+func (rs *ReportStorageImpl) MakeReport(report *Report, ctx context.Context) error {
+	_, err := rs.reports.InsertOne(ctx, report)
+	return err
+}
+
+// checks if user already has unresolved report about the same target
+func (rs *ReportStorageImpl) CheckReported(postID, commentID string, user User, ctx context.Context) (bool, error) {
+	filter := bson.M{
+		"post_id":      postID,
+		"comment_id":   commentID,
+		"reporter._id": user.UserID,
+		"resolution":   unresolved,
+	}
+	if err := rs.reports.FindOne(ctx, filter).Err(); err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (rs *ReportStorageImpl) CountReports(postID, commentID string, ctx context.Context) (int, error) {
+	filter := bson.M{"post_id": postID, "comment_id": commentID, "resolution": unresolved}
+	count, err := rs.reports.CountDocuments(ctx, filter)
+	return int(count), err
+}
+
+// most reported targets go first
+func (rs *ReportStorageImpl) GetQueue(category string, ctx context.Context) ([]*QueueItem, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"category": category, "resolution": unresolved}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":            bson.M{"post_id": "$post_id", "comment_id": "$comment_id"},
+			"count":          bson.M{"$sum": 1},
+			"reasons":        bson.M{"$push": "$reason"},
+			"first_reported": bson.M{"$min": "$created"},
+			"last_reported":  bson.M{"$max": "$created"},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"post_id": "$_id.post_id", "comment_id": "$_id.comment_id"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "first_reported", Value: 1}}}},
+	}
+	cursor, err := rs.reports.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	queue := make([]*QueueItem, 0, 10)
+	for cursor.Next(ctx) {
+		item := &QueueItem{}
+		if err := cursor.Decode(item); err != nil {
+			return nil, err
+		}
+		queue = append(queue, item)
+	}
+	return queue, cursor.Err()
+}
+
+// resolves all unresolved reports about the target at once
+func (rs *ReportStorageImpl) ResolveReports(postID, commentID string, resolution Resolution, ctx context.Context) error {
+	filter := bson.M{"post_id": postID, "comment_id": commentID, "resolution": unresolved}
+	_, err := rs.reports.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"resolution": resolution}})
+	return err
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"reddit_clone/internals/sigv4"
+)
+
+// Blobs are objects of one bucket of s3 compatible store, addressed path style
+// (endpoint/bucket/key) so that minio and local stand-ins work without dns setup
+type S3BlobStoreImpl struct {
+	endpoint *url.URL
+	bucket   string
+	creds    sigv4.Credentials
+	client   *http.Client
+}
+
+func NewS3BlobStore(endpoint, bucket, region, accessKey, secretKey string) (BlobStore, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("s3 endpoint must be http(s) url: %s", endpoint)
+	}
+	return &S3BlobStoreImpl{
+		endpoint: parsed,
+		bucket:   bucket,
+		creds: sigv4.Credentials{
+			AccessKey: accessKey,
+			SecretKey: secretKey,
+			Region:    region,
+			Service:   "s3",
+		},
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (ss *S3BlobStoreImpl) Put(key, contentType string, data []byte, ctx context.Context) error {
+	resp, err := ss.do("PUT", key, contentType, data, ctx)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (ss *S3BlobStoreImpl) Get(key string, ctx context.Context) (io.ReadCloser, error) {
+	resp, err := ss.do("GET", key, "", nil, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (ss *S3BlobStoreImpl) Delete(key string, ctx context.Context) error {
+	resp, err := ss.do("DELETE", key, "", nil, ctx)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// s3 answers 204 even if there was no such object
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (ss *S3BlobStoreImpl) do(method, key, contentType string, data []byte, ctx context.Context) (*http.Response, error) {
+	objectURL := *ss.endpoint
+	objectURL.Path = strings.TrimSuffix(objectURL.Path, "/") + "/" + ss.bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sigv4.Sign(req, sigv4.PayloadHash(data), ss.creds, time.Now())
+	return ss.client.Do(req)
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3: unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+}
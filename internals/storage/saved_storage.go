@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Saved items only reference posts and comments, so deleted content is detected when saves are read
+type SavedStorageImpl struct {
+	saved *mongo.Collection
+}
+
+func NewSavedStorage(saved *mongo.Collection) SavedStorage {
+	return &SavedStorageImpl{
+		saved: saved,
+	}
+}
+
+func (ss *SavedStorageImpl) EnsureIndexes(ctx context.Context) error {
+	_, err := ss.saved.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "post_id", Value: 1}, {Key: "comment_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created", Value: -1}}},
+	})
+	return err
+}
+
+// saving already saved item moves it to the new folder
+func (ss *SavedStorageImpl) Save(item SavedItem, user User, ctx context.Context) error {
+	filter := bson.M{"user_id": user.UserID, "post_id": item.PostID, "comment_id": item.CommentID}
+	update := bson.M{
+		"$set":         bson.M{"folder": item.Folder},
+		"$setOnInsert": bson.M{"created": time.Now()},
+	}
+	_, err := ss.saved.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (ss *SavedStorageImpl) Unsave(postID, commentID string, user User, ctx context.Context) error {
+	_, err := ss.saved.DeleteOne(ctx, bson.M{"user_id": user.UserID, "post_id": postID, "comment_id": commentID})
+	return err
+}
+
+// empty folder means all folders, newest saves first
+func (ss *SavedStorageImpl) GetSaved(folder string, opts ListOptions, user User, ctx context.Context) ([]*SavedItem, error) {
+	filter := bson.M{"user_id": user.UserID}
+	if folder != "" {
+		filter["folder"] = folder
+	}
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "created", Value: -1}}).
+		SetSkip(int64(opts.Offset)).
+		SetLimit(int64(opts.Limit))
+	cursor, err := ss.saved.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	items := make([]*SavedItem, 0, opts.Limit)
+	for cursor.Next(ctx) {
+		item := &SavedItem{}
+		if err := cursor.Decode(item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, cursor.Err()
+}
+
+func (ss *SavedStorageImpl) GetFolders(user User, ctx context.Context) ([]string, error) {
+	values, err := ss.saved.Distinct(ctx, "folder", bson.M{"user_id": user.UserID, "folder": bson.M{"$ne": ""}})
+	if err != nil {
+		return nil, err
+	}
+	folders := make([]string, 0, len(values))
+	for _, value := range values {
+		if folder, ok := value.(string); ok {
+			folders = append(folders, folder)
+		}
+	}
+	return folders, nil
+}
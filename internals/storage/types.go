@@ -4,6 +4,11 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"io"
+	"time"
+
+	"reddit_clone/internals/automod"
+	"reddit_clone/internals/misc"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -21,6 +26,7 @@ type AuthStorage interface {
 	CheckCredentials(username, password string) (int, error)
 	CreateToken(userID, username string) (string, error)
 	ValidateToken(token string) (User, error)
+	IsAdmin(userID string) (bool, error)
 }
 
 type IDtype []byte
@@ -52,28 +58,74 @@ func (id *IDtype) UnmarshalJSON(data []byte) error {
 }
 
 type NewPost struct {
-	Category string `json:"category"`
-	Type     string `json:"type"`
-	URL      string `json:"url"`
-	Title    string `json:"title"`
-	Text     string `json:"text"`
+	Category     string   `json:"category"`
+	Type         string   `json:"type"`
+	URL          string   `json:"url"`
+	Title        string   `json:"title"`
+	Text         string   `json:"text"`
+	Force        bool     `json:"force"` // post link even if it was already posted
+	CanonicalURL string   `json:"-"`     // set by handler after validation
+	Image        *Image   `json:"-"`     // set by handler after upload is stored
+	Poll         *NewPoll `json:"poll"`
+}
+
+type NewPoll struct {
+	Options  []string   `json:"options"`
+	Multiple bool       `json:"multiple"`
+	ClosesAt *time.Time `json:"closes_at"`
 }
 
+// Karma is not stored with the author, it is loaded from users when posts are read;
+// authors of everything else, like mod log entries or messages, have none
 type Author struct {
 	Username string `json:"username"`
 	ID       string `json:"id" bson:"_id"`
+	Karma    *int   `json:"karma,omitempty" bson:"-"`
+}
+
+// Deletion marks a post or comment as soft deleted; content stays in the db
+// until it is purged after the retention period
+type Deletion struct {
+	At     time.Time `json:"at"     bson:"at"`
+	By     Author    `json:"by"     bson:"by"`
+	Reason string    `json:"reason" bson:"reason,omitempty"`
+}
+
+// Approval marks content as reviewed by a moderator; approving removed content restores it
+type Approval struct {
+	At time.Time `json:"at" bson:"at"`
+	By Author    `json:"by" bson:"by"`
 }
 
 type Comment struct {
-	Created string `json:"created"`
-	Author  Author `json:"author"`
-	Body    string `json:"body"`
-	ID      IDtype `json:"id" bson:"_id,omitempty"`
+	Created  string    `json:"created"`
+	Author   Author    `json:"author"`
+	Body     string    `json:"body"`
+	BodyHTML string    `json:"body_html" bson:"body_html,omitempty"` // rendered from markdown of body when written
+	Search   string    `json:"-"         bson:"search"`              // body while everybody can see the comment, only it is searched
+	ParentID string    `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Score    int       `json:"score"`
+	Votes    []Vote    `json:"votes"`
+	Deleted  *Deletion `json:"deleted,omitempty"  bson:"deleted,omitempty"`
+	Approved *Approval `json:"approved,omitempty" bson:"approved,omitempty"`
+	Shadow   bool      `json:"-" bson:"shadow,omitempty"` // author is shadow banned
+	ID       IDtype    `json:"id" bson:"_id,omitempty"`
 }
 
+// UserComment is a comment listed outside of its post, so it carries a link back
+type UserComment struct {
+	Comment   `bson:",inline"`
+	PostID    IDtype `json:"post_id"    bson:"post_id"`
+	PostTitle string `json:"post_title" bson:"post_title"`
+	Category  string `json:"category"   bson:"category"`
+	Permalink string `json:"permalink"  bson:"-"`
+}
+
+// Shadow votes are made by shadow banned users and do not count in score
 type Vote struct {
-	ID   string `json:"id" bson:"_id"`
-	Vote int    `json:"vote"`
+	ID     string `json:"id" bson:"_id"`
+	Vote   int    `json:"vote"`
+	Shadow bool   `json:"-"  bson:"shadow,omitempty"`
 }
 
 type Post struct {
@@ -82,21 +134,159 @@ type Post struct {
 	Type             string    `json:"type"              bson:"type"`
 	Title            string    `json:"title"             bson:"title"`
 	URL              string    `json:"url"               bson:"url,omitempty"`
+	CanonicalURL     string    `json:"canonical_url,omitempty" bson:"canonical_url,omitempty"`
 	Author           Author    `json:"author"            bson:"author"`
 	Category         string    `json:"category"          bson:"category"`
 	Text             string    `json:"text"              bson:"text,omitempty"`
+	TextHTML         string    `json:"text_html,omitempty" bson:"text_html,omitempty"` // rendered from markdown of text when written
 	Votes            []Vote    `json:"votes"             bson:"votes"`
 	Comments         []Comment `json:"comments"          bson:"comments"`
 	Created          string    `json:"created"           bson:"created"`
 	UpvotePercentage int       `json:"upvotepercentage"  bson:"upvotepercentage"`
+	Deleted          *Deletion `json:"deleted,omitempty" bson:"deleted,omitempty"`
+	Approved         *Approval `json:"approved,omitempty" bson:"approved,omitempty"`
+	Locked           bool      `json:"locked"            bson:"locked"`
+	Pinned           bool      `json:"pinned"            bson:"pinned"`
+	NSFW             bool      `json:"nsfw"              bson:"nsfw"`
+	Spoiler          bool      `json:"spoiler"           bson:"spoiler"`
+	Flair            string    `json:"flair,omitempty"   bson:"flair,omitempty"`
+	Preview          *Preview  `json:"preview,omitempty" bson:"preview,omitempty"`
+	Image            *Image    `json:"image,omitempty"   bson:"image,omitempty"`
+	Poll             *Poll     `json:"poll,omitempty"    bson:"poll,omitempty"`
+	Shadow           bool      `json:"-"                 bson:"shadow,omitempty"` // author is shadow banned
 	ID               IDtype    `json:"id"                bson:"_id,omitempty"`
 }
 
+// Image of image post, files are kept in blob store and served by their keys
+type Image struct {
+	URL          string `json:"url"           bson:"url"`
+	ThumbnailURL string `json:"thumbnail_url" bson:"thumbnail_url"`
+	ContentType  string `json:"content_type"  bson:"content_type"`
+	Width        int    `json:"width"         bson:"width"`
+	Height       int    `json:"height"        bson:"height"`
+	Size         int    `json:"size"          bson:"size"`
+	Key          string `json:"-"             bson:"key"`
+	ThumbnailKey string `json:"-"             bson:"thumbnail_key"`
+}
+
+// Poll options are referred to by their index; counts and ballots are never shown,
+// votes and total are filled when post is read by someone who voted or after the poll closed
+type Poll struct {
+	Options  []PollOption `json:"options"               bson:"options"`
+	Multiple bool         `json:"multiple"              bson:"multiple"`
+	ClosesAt *time.Time   `json:"closes_at,omitempty"   bson:"closes_at,omitempty"`
+	Voters   int          `json:"-"                     bson:"voters"`
+	Ballots  []Ballot     `json:"-"                     bson:"ballots"`
+	Closed   bool         `json:"closed"                bson:"-"`
+	Voted    []int        `json:"voted,omitempty"       bson:"-"` // options chosen by the viewer
+	Total    *int         `json:"total_votes,omitempty" bson:"-"`
+}
+
+type PollOption struct {
+	Text  string `json:"text"            bson:"text"`
+	Count int    `json:"-"               bson:"count"`
+	Votes *int   `json:"votes,omitempty" bson:"-"`
+}
+
+// Ballot of shadow banned user is kept but not counted, like their votes
+type Ballot struct {
+	UserID  string `bson:"user_id"`
+	Options []int  `bson:"options"`
+	Shadow  bool   `bson:"shadow,omitempty"`
+}
+
+// Preview is what the page behind a link post says about itself,
+// it is fetched after the post is created so new posts have none for a while
+type Preview struct {
+	URL         string    `json:"url"                   bson:"url"`
+	Title       string    `json:"title,omitempty"       bson:"title,omitempty"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	Image       string    `json:"image,omitempty"       bson:"image,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"   bson:"site_name,omitempty"`
+	Fetched     time.Time `json:"fetched"               bson:"fetched"`
+}
+
+const (
+	SortNew = "new"
+	SortTop = "top"
+)
+
+type ListOptions struct {
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// Viewer is the one who requests posts, anonymous one has empty UserID
+// Shadow posts and comments are seen only by their authors,
+// posts and comments of HiddenAuthors are filtered out of everything they get,
+// HiddenPosts are filtered out of listings only and still can be opened;
+// in Moderates categories viewer sees who wrote deleted comments and why they were deleted
+type Viewer struct {
+	UserID        string
+	HiddenAuthors []string
+	HiddenPosts   []string
+	Moderates     []string
+}
+
+func (v Viewer) ModeratesCategory(category string) bool {
+	for _, name := range v.Moderates {
+		if name == category {
+			return true
+		}
+	}
+	return false
+}
+
+// checks if viewer cannot see content of the author, shadow tells if it is shadow content
+func (v Viewer) Hides(author Author, shadow bool) bool {
+	if shadow && author.ID != v.UserID {
+		return true
+	}
+	return v.HidesAuthor(author.ID)
+}
+
+func (v Viewer) HidesAuthor(authorID string) bool {
+	for _, id := range v.HiddenAuthors {
+		if id == authorID {
+			return true
+		}
+	}
+	return false
+}
+
+// empty fields are not used for filtering
+type SearchOptions struct {
+	Query    string
+	Category string
+	Author   string
+	Type     string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Offset   int
+}
+
+type SearchResult struct {
+	Post      `bson:",inline"`
+	Relevance float64 `json:"relevance" bson:"relevance"`
+}
+
 type PostStorage interface {
-	GetPost(postID string, ctx context.Context) (*Post, error)
-	GetPosts(ctx context.Context) ([]*Post, error)
-	GetPostsByCategory(category string, ctx context.Context) ([]*Post, error)
-	GetPostsByUsername(username string, ctx context.Context) ([]*Post, error)
+	EnsureIndexes(ctx context.Context) error
+
+	GetPost(postID string, viewer Viewer, ctx context.Context) (*Post, error)
+	FindPost(postID string, ctx context.Context) (*Post, error)
+	FindImagePost(key string, ctx context.Context) (*Post, error)
+	GetPosts(viewer Viewer, ctx context.Context) ([]*Post, error)
+	GetPostsByCategory(category string, viewer Viewer, ctx context.Context) ([]*Post, error)
+	GetPostsByUsername(username string, viewer Viewer, ctx context.Context) ([]*Post, error)
+	GetPostsByIDs(postIDs []string, viewer Viewer, ctx context.Context) ([]*Post, error)
+	GetFeed(categories []string, opts ListOptions, viewer Viewer, ctx context.Context) ([]*Post, error)
+	GetFeedByUsername(username string, opts ListOptions, viewer Viewer, ctx context.Context) ([]*Post, error)
+	GetCommentsByUsername(username string, opts ListOptions, viewer Viewer, ctx context.Context) ([]*UserComment, error)
+	FindReposts(category, canonicalURL string, since time.Time, viewer Viewer, ctx context.Context) ([]string, error)
+	Search(opts SearchOptions, viewer Viewer, ctx context.Context) ([]*SearchResult, error)
 
 	CheckPostExist(postID string, ctx context.Context) (bool, error)
 	CheckCommentExist(postID, commentID string, ctx context.Context) (int, error)
@@ -104,11 +294,359 @@ type PostStorage interface {
 	CheckPostOwner(postID string, user User, ctx context.Context) (bool, error)
 	CheckCommentOwner(postID, commentID string, user User, ctx context.Context) (bool, error)
 
-	MakeComment(postID, comment string, user User, ctx context.Context) error
+	MakeComment(postID, parentID, comment string, user User, ctx context.Context) (string, error)
 	MakePost(newPost *NewPost, user User, ctx context.Context) (string, error)
-	DeleteComment(postID, commentID string, ctx context.Context) error
-	DeletePost(postID string, ctx context.Context) error
+	DeleteComment(postID, commentID string, deletion Deletion, ctx context.Context) error
+	DeletePost(postID string, deletion Deletion, ctx context.Context) error
+	RestoreComment(postID, commentID string, user User, window time.Duration, ctx context.Context) (int, error)
+	RestorePost(postID string, user User, window time.Duration, ctx context.Context) (int, error)
+	PurgeDeleted(before time.Time, ctx context.Context) ([]string, error)
+	SetShadow(authorID string, shadow bool, ctx context.Context) error
 
 	Rate(postID string, rating int, user User, ctx context.Context) error
 	Unrate(postID string, user User, ctx context.Context) error
+	RateComment(postID, commentID string, rating int, user User, ctx context.Context) error
+	UnrateComment(postID, commentID string, user User, ctx context.Context) error
+	// returns false if the user has already voted or the poll is closed
+	VotePoll(postID string, options []int, user User, ctx context.Context) (bool, error)
+
+	ApproveComment(postID, commentID string, approval Approval, ctx context.Context) error
+	ApprovePost(postID string, approval Approval, ctx context.Context) error
+	LockPost(postID string, locked bool, ctx context.Context) error
+	PinPost(postID string, pinned bool, ctx context.Context) error
+	MarkPost(postID string, nsfw, spoiler bool, ctx context.Context) error
+	SetFlair(postID, flair string, ctx context.Context) error
+	SetPreview(postID string, preview Preview, ctx context.Context) error
+}
+
+type NewCategory struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Rules       []string `json:"rules"`
+}
+
+// Owner is a moderator too, but is not listed in Moderators
+type Category struct {
+	Name        string    `json:"name"        bson:"_id"`
+	Description string    `json:"description" bson:"description"`
+	Rules       []string  `json:"rules"       bson:"rules"`
+	Owner       Author    `json:"owner"       bson:"owner"`
+	Moderators  []Author  `json:"moderators"  bson:"moderators"`
+	Created     time.Time `json:"created"     bson:"created"`
+
+	Automod []automod.Rule    `json:"-"       bson:"automod"` // only moderators can see rules
+	Domains misc.DomainPolicy `json:"domains" bson:"domains"`
+}
+
+type CategoryStorage interface {
+	GetCategory(name string, ctx context.Context) (*Category, error)
+	GetCategories(ctx context.Context) ([]*Category, error)
+	CheckCategoryExist(name string, ctx context.Context) (bool, error)
+	CreateCategory(newCategory *NewCategory, user User, ctx context.Context) error
+
+	Subscribe(category string, user User, ctx context.Context) error
+	Unsubscribe(category string, user User, ctx context.Context) error
+	GetSubscriptions(user User, ctx context.Context) ([]string, error)
+
+	AddModerator(category string, moderator Author, ctx context.Context) error
+	RemoveModerator(category string, moderator Author, ctx context.Context) error
+	IsModerator(category string, user User, ctx context.Context) (bool, error)
+	GetModerated(user User, ctx context.Context) ([]string, error)
+
+	SetAutomod(category string, rules []automod.Rule, ctx context.Context) error
+	SetDomains(category string, domains misc.DomainPolicy, ctx context.Context) error
+}
+
+const (
+	ResolutionApprove = "approve"
+	ResolutionRemove  = "remove"
+	ResolutionIgnore  = "ignore"
+)
+
+type Resolution struct {
+	Action string    `json:"action"           bson:"action"`
+	By     Author    `json:"by"               bson:"by"`
+	At     time.Time `json:"at"               bson:"at"`
+	Reason string    `json:"reason,omitempty" bson:"reason,omitempty"`
+}
+
+// Report is about a post or, if CommentID is not empty, about a comment of the post
+type Report struct {
+	ID         primitive.ObjectID `json:"id"                   bson:"_id,omitempty"`
+	Category   string             `json:"category"             bson:"category"`
+	PostID     string             `json:"post_id"              bson:"post_id"`
+	CommentID  string             `json:"comment_id,omitempty" bson:"comment_id"`
+	Reporter   Author             `json:"reporter"             bson:"reporter"`
+	Reason     string             `json:"reason"               bson:"reason"`
+	Created    time.Time          `json:"created"              bson:"created"`
+	Resolution *Resolution        `json:"resolution,omitempty" bson:"resolution,omitempty"`
+}
+
+// QueueItem aggregates all unresolved reports about the same post or comment
+type QueueItem struct {
+	PostID        string    `json:"post_id"              bson:"post_id"`
+	CommentID     string    `json:"comment_id,omitempty" bson:"comment_id"`
+	Count         int       `json:"count"                bson:"count"`
+	Reasons       []string  `json:"reasons"              bson:"reasons"`
+	FirstReported time.Time `json:"first_reported"       bson:"first_reported"`
+	LastReported  time.Time `json:"last_reported"        bson:"last_reported"`
+}
+
+type ReportStorage interface {
+	MakeReport(report *Report, ctx context.Context) error
+	CheckReported(postID, commentID string, user User, ctx context.Context) (bool, error)
+	CountReports(postID, commentID string, ctx context.Context) (int, error)
+	GetQueue(category string, ctx context.Context) ([]*QueueItem, error)
+	ResolveReports(postID, commentID string, resolution Resolution, ctx context.Context) error
+}
+
+const (
+	ModActionRemovePost      = "remove_post"
+	ModActionRemoveComment   = "remove_comment"
+	ModActionApprovePost     = "approve_post"
+	ModActionApproveComment  = "approve_comment"
+	ModActionRestorePost     = "restore_post"
+	ModActionRestoreComment  = "restore_comment"
+	ModActionLock            = "lock"
+	ModActionUnlock          = "unlock"
+	ModActionPin             = "pin"
+	ModActionUnpin           = "unpin"
+	ModActionMark            = "mark"
+	ModActionAddModerator    = "add_moderator"
+	ModActionRemoveModerator = "remove_moderator"
+	ModActionBan             = "ban"
+	ModActionUnban           = "unban"
+	ModActionSuspend         = "suspend"
+	ModActionUnsuspend       = "unsuspend"
+	ModActionShadowBan       = "shadow_ban"
+	ModActionUnshadowBan     = "unshadow_ban"
+)
+
+type ModLogTarget struct {
+	PostID    string `json:"post_id,omitempty"    bson:"post_id,omitempty"`
+	CommentID string `json:"comment_id,omitempty" bson:"comment_id,omitempty"`
+	Username  string `json:"username,omitempty"   bson:"username,omitempty"`
+}
+
+type ModLogEntry struct {
+	ID        primitive.ObjectID `json:"id"               bson:"_id,omitempty"`
+	Category  string             `json:"category"         bson:"category"`
+	Moderator Author             `json:"moderator"        bson:"moderator"`
+	Action    string             `json:"action"           bson:"action"`
+	Target    ModLogTarget       `json:"target"           bson:"target"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	Created   time.Time          `json:"created"          bson:"created"`
+}
+
+// empty fields are not used for filtering
+type ModLogFilter struct {
+	Category  string
+	Moderator string
+	Action    string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+	Offset    int
+}
+
+// Mod log is append only, so there are no methods to change or delete entries
+type ModLogStorage interface {
+	AddEntry(entry *ModLogEntry, ctx context.Context) error
+	RemoveEntry(entryID primitive.ObjectID, ctx context.Context) error
+	GetEntries(filter ModLogFilter, ctx context.Context) ([]*ModLogEntry, error)
+}
+
+// Ban is either site-wide suspension or ban in a single category; nil Until means permanent
+type Ban struct {
+	Reason  string     `json:"reason"`
+	By      Author     `json:"by"`
+	Created time.Time  `json:"created"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// Expired bans are lifted automatically: Get methods return nil for them
+type BanStorage interface {
+	Suspend(userID string, ban Ban, ctx context.Context) error
+	Unsuspend(userID string, ctx context.Context) error
+	GetSuspension(userID string, ctx context.Context) (*Ban, error)
+
+	ShadowBan(userID string, shadowBanned bool, ctx context.Context) error
+	IsShadowBanned(userID string, ctx context.Context) (bool, error)
+	GetShadowBanned(ctx context.Context) ([]string, error)
+
+	BanFromCategory(category, userID string, ban Ban, ctx context.Context) error
+	UnbanFromCategory(category, userID string, ctx context.Context) error
+	GetCategoryBan(category, userID string, ctx context.Context) (*Ban, error)
+}
+
+// SavedItem is a saved post, or a comment if CommentID is set;
+// Post and Comment are filled from posts and stay empty if the content is not available anymore
+type SavedItem struct {
+	PostID      string    `json:"post_id"              bson:"post_id"`
+	CommentID   string    `json:"comment_id,omitempty" bson:"comment_id"`
+	Folder      string    `json:"folder,omitempty"     bson:"folder"`
+	Created     time.Time `json:"created"              bson:"created"`
+	Unavailable bool      `json:"unavailable"          bson:"-"`
+	Post        *Post     `json:"post,omitempty"       bson:"-"`
+	Comment     *Comment  `json:"comment,omitempty"    bson:"-"`
+}
+
+type SavedStorage interface {
+	EnsureIndexes(ctx context.Context) error
+	Save(item SavedItem, user User, ctx context.Context) error
+	Unsave(postID, commentID string, user User, ctx context.Context) error
+	GetSaved(folder string, opts ListOptions, user User, ctx context.Context) ([]*SavedItem, error)
+	GetFolders(user User, ctx context.Context) ([]string, error)
+}
+
+type HideStorage interface {
+	HidePost(postID string, user User, ctx context.Context) error
+	UnhidePost(postID string, user User, ctx context.Context) error
+	GetHiddenPosts(userID string, ctx context.Context) ([]string, error)
+
+	Block(blocked Author, user User, ctx context.Context) error
+	Unblock(blockedID string, user User, ctx context.Context) error
+	GetBlocked(userID string, ctx context.Context) ([]Author, error)
+	IsBlocked(userID, byID string, ctx context.Context) (bool, error)
+}
+
+const (
+	NotificationPostReply    = "post_reply"
+	NotificationCommentReply = "comment_reply"
+	NotificationMention      = "mention"
+)
+
+// Notification tells the user about a comment made by someone else
+type Notification struct {
+	ID        primitive.ObjectID `json:"id"         bson:"_id,omitempty"`
+	UserID    string             `json:"-"          bson:"user_id"`
+	Type      string             `json:"type"       bson:"type"`
+	From      Author             `json:"from"       bson:"from"`
+	PostID    string             `json:"post_id"    bson:"post_id"`
+	PostTitle string             `json:"post_title" bson:"-"` // loaded from the post when read, like body
+	Category  string             `json:"category"   bson:"category"`
+	CommentID string             `json:"comment_id" bson:"comment_id"`
+	Body      string             `json:"body"       bson:"-"`
+	Created   time.Time          `json:"created"    bson:"created"`
+	Read      bool               `json:"read"       bson:"read"`
+}
+
+type NotificationStorage interface {
+	AddNotifications(notifications []*Notification, ctx context.Context) error
+	// notifications from blocked users are hidden, also the ones made before they were blocked
+	GetNotifications(userID string, blocked []string, unreadOnly bool, opts ListOptions, ctx context.Context) ([]*Notification, error)
+	CountUnread(userID string, blocked []string, ctx context.Context) (int, error)
+	// empty ids marks all notifications of the user as read
+	MarkRead(userID string, ids []string, ctx context.Context) error
+}
+
+// Shadow messages are sent by shadow banned users and are never delivered;
+// ReadAt is set when the recipient reads the conversation
+type DirectMessage struct {
+	ID             primitive.ObjectID `json:"id"                bson:"_id,omitempty"`
+	ConversationID string             `json:"conversation_id"   bson:"conversation_id"`
+	From           Author             `json:"from"              bson:"from"`
+	To             Author             `json:"to"                bson:"to"`
+	Body           string             `json:"body"              bson:"body"`
+	Created        time.Time          `json:"created"           bson:"created"`
+	ReadAt         *time.Time         `json:"read_at,omitempty" bson:"read_at,omitempty"`
+	Shadow         bool               `json:"-"                 bson:"shadow,omitempty"`
+}
+
+type Conversation struct {
+	ID     string        `json:"id"     bson:"_id"`
+	With   Author        `json:"with"   bson:"-"`
+	Last   DirectMessage `json:"last"   bson:"last"`
+	Unread int           `json:"unread" bson:"unread"`
+}
+
+type MessageStorage interface {
+	EnsureIndexes(ctx context.Context) error
+	SendMessage(message *DirectMessage, ctx context.Context) error
+	// messages from blocked users are left out of inbox, conversations and unread count
+	GetInbox(userID string, blocked []string, opts ListOptions, ctx context.Context) ([]*DirectMessage, error)
+	GetSent(userID string, opts ListOptions, ctx context.Context) ([]*DirectMessage, error)
+	GetConversation(userID, otherID string, opts ListOptions, ctx context.Context) ([]*DirectMessage, error)
+	GetConversations(userID string, blocked []string, opts ListOptions, ctx context.Context) ([]*Conversation, error)
+	MarkRead(userID, otherID string, readAt time.Time, ctx context.Context) error
+	CountUnread(userID string, blocked []string, ctx context.Context) (int, error)
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead" // all attempts failed, only a manual retry sends it again
+)
+
+// Webhook gets events of given types; empty Category means all categories
+type Webhook struct {
+	ID        primitive.ObjectID `json:"id"               bson:"_id,omitempty"`
+	URL       string             `json:"url"              bson:"url"`
+	Events    []string           `json:"events"           bson:"events"`
+	Category  string             `json:"category"         bson:"category"`
+	Secret    string             `json:"secret,omitempty" bson:"secret"`
+	CreatedBy Author             `json:"created_by"       bson:"created_by"`
+	Created   time.Time          `json:"created"          bson:"created"`
+}
+
+type DeliveryAttempt struct {
+	At         time.Time     `json:"at"                    bson:"at"`
+	StatusCode int           `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"       bson:"error,omitempty"`
+	Duration   time.Duration `json:"duration"              bson:"duration"`
+}
+
+// WebhookDelivery is one event for one webhook together with the log of its attempts
+type WebhookDelivery struct {
+	ID          primitive.ObjectID `json:"id"           bson:"_id,omitempty"`
+	WebhookID   primitive.ObjectID `json:"webhook_id"   bson:"webhook_id"`
+	Event       string             `json:"event"        bson:"event"`
+	Payload     string             `json:"payload"      bson:"payload"`
+	Status      string             `json:"status"       bson:"status"`
+	NextAttempt time.Time          `json:"next_attempt" bson:"next_attempt"`
+	Attempts    []DeliveryAttempt  `json:"attempts"     bson:"attempts"`
+	Created     time.Time          `json:"created"      bson:"created"`
+}
+
+type WebhookStorage interface {
+	CreateWebhook(webhook *Webhook, ctx context.Context) error
+	// returns nil if there is no such webhook
+	GetWebhook(webhookID string, ctx context.Context) (*Webhook, error)
+	GetWebhooks(ctx context.Context) ([]*Webhook, error)
+	DeleteWebhook(webhookID string, ctx context.Context) error
+	FindWebhooks(event, category string, ctx context.Context) ([]*Webhook, error)
+
+	AddDeliveries(deliveries []*WebhookDelivery, ctx context.Context) error
+	// takes a due pending delivery and hides it from others for lease; returns nil if there is none
+	ClaimDelivery(now time.Time, lease time.Duration, ctx context.Context) (*WebhookDelivery, error)
+	RecordAttempt(deliveryID primitive.ObjectID, attempt DeliveryAttempt, status string, nextAttempt time.Time, ctx context.Context) error
+	// empty status means all deliveries, newest first
+	GetDeliveries(webhookID, status string, opts ListOptions, ctx context.Context) ([]*WebhookDelivery, error)
+	// makes dead delivery pending again, returns false if there is no such dead delivery
+	RetryDelivery(webhookID, deliveryID string, now time.Time, ctx context.Context) (bool, error)
+}
+
+type Profile struct {
+	Username     string    `json:"username"`
+	ID           string    `json:"id"`
+	Created      time.Time `json:"created"`
+	Karma        int       `json:"karma"`
+	PostKarma    int       `json:"post_karma"`
+	CommentKarma int       `json:"comment_karma"`
+	PostCount    int       `json:"post_count"`
+	CommentCount int       `json:"comment_count"`
+}
+
+type UserStorage interface {
+	GetProfile(username string, ctx context.Context) (*Profile, error)
+}
+
+// BlobStore keeps uploaded files; keys are made by handlers from random hex and an extension,
+// so they are safe to use as file names and object keys
+type BlobStore interface {
+	Put(key, contentType string, data []byte, ctx context.Context) error
+	// returns nil if there is no such blob
+	Get(key string, ctx context.Context) (io.ReadCloser, error)
+	// deleting missing blob is not an error
+	Delete(key string, ctx context.Context) error
 }
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Users and their karma are in postgres, but history comes from posts in mongo
+type UserStorageImpl struct {
+	users *sql.DB
+	posts *mongo.Collection
+}
+
+func NewUserStorage(users *sql.DB, posts *mongo.Collection) UserStorage {
+	return &UserStorageImpl{
+		users: users,
+		posts: posts,
+	}
+}
+
+func (us *UserStorageImpl) GetProfile(username string, ctx context.Context) (*Profile, error) {
+	profile := &Profile{Username: username}
+	var rawID []byte
+	row := us.users.QueryRowContext(ctx, "SELECT user_id, created, post_karma, comment_karma FROM users WHERE username = $1;", username)
+	if err := row.Scan(&rawID, &profile.Created, &profile.PostKarma, &profile.CommentKarma); err != nil {
+		return nil, err
+	}
+	profile.ID = hex.EncodeToString(rawID)
+
+	if err := us.getPostStats(profile, ctx); err != nil {
+		return nil, err
+	}
+	if err := us.getCommentStats(profile, ctx); err != nil {
+		return nil, err
+	}
+	profile.Karma = profile.PostKarma + profile.CommentKarma
+	return profile, nil
+}
+
+func (us *UserStorageImpl) getPostStats(profile *Profile, ctx context.Context) error {
+	count, err := us.posts.CountDocuments(ctx, bson.M{"author._id": profile.ID, "deleted": notDeleted})
+	profile.PostCount = int(count)
+	return err
+}
+
+func (us *UserStorageImpl) getCommentStats(profile *Profile, ctx context.Context) error {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"comments.author._id": profile.ID, "deleted": notDeleted}},
+		bson.M{"$unwind": "$comments"},
+		bson.M{"$match": bson.M{"comments.author._id": profile.ID, "comments.deleted": notDeleted}},
+		bson.M{"$count": "count"},
+	}
+	cursor, err := us.posts.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	res := struct {
+		Count int `bson:"count"`
+	}{}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&res); err != nil {
+			return err
+		}
+	}
+	profile.CommentCount = res.Count
+	return cursor.Err()
+}
@@ -1,8 +1,36 @@
+DROP TABLE IF EXISTS category_bans;
+DROP TABLE IF EXISTS suspensions;
 DROP TABLE IF EXISTS users;
 CREATE TABLE users (
-    id        serial primary key,
-    user_id   bytea       not null unique check (length(user_id) = 16),
-    username  varchar(32) not null unique,
-    password  bytea       not null
+    id            serial primary key,
+    user_id       bytea       not null unique check (length(user_id) = 16),
+    username      varchar(32) not null unique,
+    password      bytea       not null,
+    is_admin      boolean     not null default false,
+    shadow_banned boolean     not null default false,
+    post_karma    integer     not null default 0,
+    comment_karma integer     not null default 0,
+    created       timestamptz not null default now()
 );
 SELECT nextval(pg_get_serial_sequence('users', 'id'));
+
+-- site-wide suspension, null until means permanent; expired rows are just ignored
+CREATE TABLE suspensions (
+    user_id   bytea        primary key references users(user_id) on delete cascade,
+    reason    text         not null,
+    by_id     bytea        not null,
+    by_name   varchar(32)  not null,
+    created   timestamptz  not null default now(),
+    until     timestamptz
+);
+
+CREATE TABLE category_bans (
+    category  varchar(32)  not null,
+    user_id   bytea        not null references users(user_id) on delete cascade,
+    reason    text         not null,
+    by_id     bytea        not null,
+    by_name   varchar(32)  not null,
+    created   timestamptz  not null default now(),
+    until     timestamptz,
+    primary key (category, user_id)
+);
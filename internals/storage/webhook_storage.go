@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Deliveries are a queue kept in mongo, so pending ones survive restarts and are shared by instances
+type WebhookStorageImpl struct {
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
+}
+
+func NewWebhookStorage(webhooks, deliveries *mongo.Collection) WebhookStorage {
+	return &WebhookStorageImpl{
+		webhooks:   webhooks,
+		deliveries: deliveries,
+	}
+}
+
+func (ws *WebhookStorageImpl) CreateWebhook(webhook *Webhook, ctx context.Context) error {
+	res, err := ws.webhooks.InsertOne(ctx, webhook)
+	if err != nil {
+		return err
+	}
+	webhook.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (ws *WebhookStorageImpl) GetWebhook(webhookID string, ctx context.Context) (*Webhook, error) {
+	id, err := primitive.ObjectIDFromHex(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	webhook := &Webhook{}
+	if err := ws.webhooks.FindOne(ctx, bson.M{"_id": id}).Decode(webhook); err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (ws *WebhookStorageImpl) GetWebhooks(ctx context.Context) ([]*Webhook, error) {
+	return ws.findWebhooks(bson.M{}, ctx)
+}
+
+// pending deliveries of deleted webhook die when they are claimed
+func (ws *WebhookStorageImpl) DeleteWebhook(webhookID string, ctx context.Context) error {
+	id, err := primitive.ObjectIDFromHex(webhookID)
+	if err != nil {
+		return err
+	}
+	_, err = ws.webhooks.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (ws *WebhookStorageImpl) FindWebhooks(event, category string, ctx context.Context) ([]*Webhook, error) {
+	return ws.findWebhooks(bson.M{"events": event, "category": bson.M{"$in": bson.A{"", category}}}, ctx)
+}
+
+func (ws *WebhookStorageImpl) findWebhooks(filter bson.M, ctx context.Context) ([]*Webhook, error) {
+	cursor, err := ws.webhooks.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	webhooks := make([]*Webhook, 0, 4)
+	for cursor.Next(ctx) {
+		webhook := &Webhook{}
+		if err := cursor.Decode(webhook); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, cursor.Err()
+}
+
+func (ws *WebhookStorageImpl) AddDeliveries(deliveries []*WebhookDelivery, ctx context.Context) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		docs = append(docs, delivery)
+	}
+	_, err := ws.deliveries.InsertMany(ctx, docs)
+	return err
+}
+
+// claiming moves next attempt past the lease, so delivery of crashed sender is retried after it
+func (ws *WebhookStorageImpl) ClaimDelivery(now time.Time, lease time.Duration, ctx context.Context) (*WebhookDelivery, error) {
+	filter := bson.M{"status": DeliveryPending, "next_attempt": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"next_attempt": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"next_attempt": 1})
+	delivery := &WebhookDelivery{}
+	if err := ws.deliveries.FindOneAndUpdate(ctx, filter, update, opts).Decode(delivery); err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (ws *WebhookStorageImpl) RecordAttempt(deliveryID primitive.ObjectID, attempt DeliveryAttempt, status string, nextAttempt time.Time, ctx context.Context) error {
+	update := bson.M{
+		"$set":  bson.M{"status": status, "next_attempt": nextAttempt},
+		"$push": bson.M{"attempts": attempt},
+	}
+	_, err := ws.deliveries.UpdateByID(ctx, deliveryID, update)
+	return err
+}
+
+func (ws *WebhookStorageImpl) GetDeliveries(webhookID, status string, opts ListOptions, ctx context.Context) ([]*WebhookDelivery, error) {
+	id, err := primitive.ObjectIDFromHex(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"webhook_id": id}
+	if status != "" {
+		filter["status"] = status
+	}
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64(opts.Offset)).
+		SetLimit(int64(opts.Limit))
+	cursor, err := ws.deliveries.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	deliveries := make([]*WebhookDelivery, 0, opts.Limit)
+	for cursor.Next(ctx) {
+		delivery := &WebhookDelivery{}
+		if err := cursor.Decode(delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, cursor.Err()
+}
+
+func (ws *WebhookStorageImpl) RetryDelivery(webhookID, deliveryID string, now time.Time, ctx context.Context) (bool, error) {
+	hookID, err := primitive.ObjectIDFromHex(webhookID)
+	if err != nil {
+		return false, err
+	}
+	id, err := primitive.ObjectIDFromHex(deliveryID)
+	if err != nil {
+		return false, err
+	}
+	filter := bson.M{"_id": id, "webhook_id": hookID, "status": DeliveryDead}
+	update := bson.M{"$set": bson.M{"status": DeliveryPending, "next_attempt": now}}
+	res, err := ws.deliveries.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
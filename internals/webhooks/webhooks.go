@@ -0,0 +1,228 @@
+// Package webhooks delivers signed event payloads to registered urls.
+// Deliveries are queued in storage and sent by a background loop with retries and backoff;
+// a delivery which fails every attempt becomes dead until it is retried manually.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"reddit_clone/internals/storage"
+)
+
+const (
+	Ping = "ping"
+
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	// how far timestamp of delivery can be from the clock of receiver
+	DefaultTolerance = 5 * time.Minute
+)
+
+// Payload is the json body of every delivery
+type Payload struct {
+	Event     string    `json:"event"`
+	Category  string    `json:"category,omitempty"`
+	PostID    string    `json:"post_id,omitempty"`
+	CommentID string    `json:"comment_id,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// signature is hex hmac-sha256 of "timestamp.body", so old bodies cannot be replayed with a new timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signed timestamp must be within tolerance from now, so captured deliveries cannot be replayed later
+func Verify(secret, timestamp string, body []byte, signature string, tolerance time.Duration) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+type Dispatcher struct {
+	Storage     storage.WebhookStorage
+	Client      *http.Client
+	MaxAttempts int           // failed attempts in a row before delivery is dead
+	Backoff     time.Duration // wait after first failure, doubled after every next one
+	MaxBackoff  time.Duration
+	Lease       time.Duration // how long claimed delivery is hidden from other senders
+
+	wake chan struct{}
+}
+
+func NewDispatcher(webhookStorage storage.WebhookStorage) *Dispatcher {
+	return &Dispatcher{
+		Storage:     webhookStorage,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 6,
+		Backoff:     30 * time.Second,
+		MaxBackoff:  time.Hour,
+		Lease:       time.Minute,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// queues payload for every webhook subscribed to its event and category
+func (d *Dispatcher) Enqueue(payload Payload, ctx context.Context) error {
+	webhooks, err := d.Storage.FindWebhooks(payload.Event, payload.Category, ctx)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	return d.enqueue(webhooks, payload, ctx)
+}
+
+// queues ping for one webhook, whatever events it is subscribed to
+func (d *Dispatcher) Ping(webhook *storage.Webhook, ctx context.Context) error {
+	return d.enqueue([]*storage.Webhook{webhook}, Payload{Event: Ping, Created: time.Now()}, ctx)
+}
+
+func (d *Dispatcher) enqueue(webhooks []*storage.Webhook, payload Payload, ctx context.Context) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	deliveries := make([]*storage.WebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		deliveries = append(deliveries, &storage.WebhookDelivery{
+			WebhookID:   webhook.ID,
+			Event:       payload.Event,
+			Payload:     string(body),
+			Status:      storage.DeliveryPending,
+			NextAttempt: now,
+			Attempts:    []storage.DeliveryAttempt{},
+			Created:     now,
+		})
+	}
+	if err := d.Storage.AddDeliveries(deliveries, ctx); err != nil {
+		return err
+	}
+	d.Wake()
+	return nil
+}
+
+// makes Run look for due deliveries right away
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sends due deliveries every interval and when woken up, runs until ctx is done
+func (d *Dispatcher) Run(interval time.Duration, ctx context.Context) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		d.sendDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) sendDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		delivery, err := d.Storage.ClaimDelivery(time.Now(), d.Lease, ctx)
+		if err != nil {
+			log.Printf("webhooks/webhooks.go: sendDue: cannot claim delivery: %s\n", err)
+			return
+		} else if delivery == nil {
+			return
+		}
+		if err := d.send(delivery, ctx); err != nil {
+			log.Printf("webhooks/webhooks.go: sendDue: cannot record attempt: %s\n", err)
+		}
+	}
+}
+
+func (d *Dispatcher) send(delivery *storage.WebhookDelivery, ctx context.Context) error {
+	start := time.Now()
+	attempt := storage.DeliveryAttempt{At: start}
+	webhook, err := d.Storage.GetWebhook(delivery.WebhookID.Hex(), ctx)
+	if err != nil {
+		return err
+	} else if webhook == nil {
+		attempt.Error = "webhook was deleted"
+		return d.Storage.RecordAttempt(delivery.ID, attempt, storage.DeliveryDead, start, ctx)
+	}
+
+	attempt.StatusCode, err = d.post(webhook, delivery, ctx)
+	attempt.Duration = time.Since(start)
+	if err != nil {
+		attempt.Error = err.Error()
+	} else if attempt.StatusCode/100 != 2 {
+		attempt.Error = fmt.Sprintf("unexpected status %d", attempt.StatusCode)
+	} else {
+		return d.Storage.RecordAttempt(delivery.ID, attempt, storage.DeliveryDelivered, start, ctx)
+	}
+
+	// manual retry of dead delivery gives it another full round of attempts
+	failures := len(delivery.Attempts) + 1
+	if failures%d.MaxAttempts == 0 {
+		return d.Storage.RecordAttempt(delivery.ID, attempt, storage.DeliveryDead, start, ctx)
+	}
+	return d.Storage.RecordAttempt(delivery.ID, attempt, storage.DeliveryPending, start.Add(d.backoff(failures%d.MaxAttempts)), ctx)
+}
+
+func (d *Dispatcher) backoff(failures int) time.Duration {
+	backoff := d.Backoff
+	for i := 1; i < failures && backoff < d.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.MaxBackoff {
+		backoff = d.MaxBackoff
+	}
+	return backoff
+}
+
+func (d *Dispatcher) post(webhook *storage.Webhook, delivery *storage.WebhookDelivery, ctx context.Context) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,181 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"reddit_clone/internals/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"post_created"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", secret, now, body, Sign(secret, now, body), true},
+		{"wrong secret", "other secret", now, body, Sign(secret, now, body), false},
+		{"changed body", secret, now, []byte(`{"event":"post_deleted"}`), Sign(secret, now, body), false},
+		{"timestamp of other signature", secret, now, body, Sign(secret, stale, body), false},
+		{"stale timestamp", secret, stale, body, Sign(secret, stale, body), false},
+		{"future timestamp", secret, future, body, Sign(secret, future, body), false},
+		{"bad timestamp", secret, "yesterday", body, Sign(secret, "yesterday", body), false},
+		{"no signature", secret, now, body, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.timestamp, tt.body, tt.signature, DefaultTolerance); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{Backoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{5, 5 * time.Minute},
+		{10, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// memoryStorage keeps one webhook and one delivery and applies recorded attempts to it
+type memoryStorage struct {
+	storage.WebhookStorage
+	webhook  *storage.Webhook
+	delivery *storage.WebhookDelivery
+}
+
+func (ms *memoryStorage) GetWebhook(webhookID string, ctx context.Context) (*storage.Webhook, error) {
+	return ms.webhook, nil
+}
+
+func (ms *memoryStorage) RecordAttempt(deliveryID primitive.ObjectID, attempt storage.DeliveryAttempt, status string, nextAttempt time.Time, ctx context.Context) error {
+	ms.delivery.Attempts = append(ms.delivery.Attempts, attempt)
+	ms.delivery.Status = status
+	ms.delivery.NextAttempt = nextAttempt
+	return nil
+}
+
+func newTestDispatcher(handler http.HandlerFunc) (*Dispatcher, *memoryStorage, func()) {
+	server := httptest.NewServer(handler)
+	ms := &memoryStorage{
+		webhook: &storage.Webhook{ID: primitive.NewObjectID(), URL: server.URL, Secret: secret},
+		delivery: &storage.WebhookDelivery{
+			ID:       primitive.NewObjectID(),
+			Event:    "post_created",
+			Payload:  `{"event":"post_created"}`,
+			Status:   storage.DeliveryPending,
+			Attempts: []storage.DeliveryAttempt{},
+		},
+	}
+	d := NewDispatcher(ms)
+	d.Client = server.Client()
+	d.MaxAttempts = 3
+	return d, ms, server.Close
+}
+
+func TestSendDelivers(t *testing.T) {
+	d, ms, stop := newTestDispatcher(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !Verify(secret, r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader), DefaultTolerance) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(EventHeader) != "post_created" || r.Header.Get(DeliveryHeader) == "" {
+			http.Error(w, "missing headers", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer stop()
+
+	if err := d.send(ms.delivery, context.Background()); err != nil {
+		t.Fatalf("send() = %v", err)
+	}
+	if ms.delivery.Status != storage.DeliveryDelivered {
+		t.Errorf("status = %q, want %q, attempts %+v", ms.delivery.Status, storage.DeliveryDelivered, ms.delivery.Attempts)
+	}
+	if attempt := ms.delivery.Attempts[0]; attempt.StatusCode != http.StatusNoContent || attempt.Error != "" {
+		t.Errorf("attempt = %+v, want status 204 without error", attempt)
+	}
+}
+
+func TestSendDeadLetters(t *testing.T) {
+	d, ms, stop := newTestDispatcher(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	})
+	defer stop()
+
+	for i := 1; i <= d.MaxAttempts; i++ {
+		start := time.Now()
+		if err := d.send(ms.delivery, context.Background()); err != nil {
+			t.Fatalf("send() = %v", err)
+		}
+		if i < d.MaxAttempts {
+			if ms.delivery.Status != storage.DeliveryPending {
+				t.Fatalf("status after %d failures = %q, want %q", i, ms.delivery.Status, storage.DeliveryPending)
+			}
+			if wait := ms.delivery.NextAttempt.Sub(start); wait < d.backoff(i) {
+				t.Errorf("next attempt after %d failures in %s, want at least %s", i, wait, d.backoff(i))
+			}
+		} else if ms.delivery.Status != storage.DeliveryDead {
+			t.Fatalf("status after %d failures = %q, want %q", i, ms.delivery.Status, storage.DeliveryDead)
+		}
+	}
+	if attempt := ms.delivery.Attempts[0]; attempt.StatusCode != http.StatusInternalServerError || attempt.Error == "" {
+		t.Errorf("attempt = %+v, want status 500 with error", attempt)
+	}
+
+	// manual retry gives dead delivery another full round
+	ms.delivery.Status = storage.DeliveryPending
+	if err := d.send(ms.delivery, context.Background()); err != nil {
+		t.Fatalf("send() = %v", err)
+	}
+	if ms.delivery.Status != storage.DeliveryPending {
+		t.Errorf("status after retry = %q, want %q", ms.delivery.Status, storage.DeliveryPending)
+	}
+}
+
+func TestSendDeletedWebhook(t *testing.T) {
+	d, ms, stop := newTestDispatcher(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("deleted webhook was called")
+	})
+	defer stop()
+	ms.webhook = nil
+
+	if err := d.send(ms.delivery, context.Background()); err != nil {
+		t.Fatalf("send() = %v", err)
+	}
+	if ms.delivery.Status != storage.DeliveryDead {
+		t.Errorf("status = %q, want %q", ms.delivery.Status, storage.DeliveryDead)
+	}
+}